@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// UpgradeHeaderPrefix prefixes the client-connected response headers set on the upgrade response
+// e.g. a Ws-Upgrade-Header-X-Tenant response header is sent to the client as X-Tenant
+// Set-Cookie response headers are passed to the upgrade response as they are
+const UpgradeHeaderPrefix = "Ws-Upgrade-Header-"
+
+// StatusError is returned when the backend answers a delivery with a non-2xx status code
+type StatusError struct {
+	// Url is the webhook URL
+	Url string
+	// StatusCode is the status code of the backend response
+	StatusCode int
+	// Header contains the backend response headers
+	Header http.Header
+	// Body contains the backend response body
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unsuccessful delivery to %s (status %d)", e.Url, e.StatusCode)
+}
+
+// UpgradeHeader extracts the headers of a backend response that are set on the client upgrade response
+// Returns the Set-Cookie headers and the headers prefixed with UpgradeHeaderPrefix without the prefix
+// WebSocket handshake headers (Sec-WebSocket-*, Upgrade, Connection) cannot be overridden and are skipped
+func UpgradeHeader(res http.Header) http.Header {
+	h := http.Header{}
+	for name, values := range res {
+		if name == "Set-Cookie" {
+			h[name] = values
+			continue
+		}
+
+		upgradeName, ok := strings.CutPrefix(name, UpgradeHeaderPrefix)
+		if !ok || upgradeName == "" {
+			continue
+		}
+
+		upgradeName = http.CanonicalHeaderKey(upgradeName)
+		if strings.HasPrefix(upgradeName, "Sec-Websocket-") || upgradeName == "Upgrade" || upgradeName == "Connection" {
+			continue
+		}
+
+		h[upgradeName] = values
+	}
+
+	return h
+}
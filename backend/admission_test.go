@@ -0,0 +1,85 @@
+package backend
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookAdmissionRejected(t *testing.T) {
+	assert := assert.New(t)
+	res := fakeResponse(http.StatusServiceUnavailable, []byte("try later"))
+	res.Header.Set(UpgradeHeaderPrefix+"Retry-After", "10")
+	fc := fakeHttpClient{
+		Responses: []*http.Response{res, fakeResponse(http.StatusOK, nil)},
+	}
+	sink := &testDeadLetterSink{}
+	wh := WebhookBackend{
+		url:        "http://backend/wh/" + uuid.NewString(),
+		client:     &fc,
+		retry:      newRetryPolicy(&RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		deadLetter: sink,
+	}
+
+	err := wh.Send(BackendMessage{
+		SessionId: uuid.NewString(),
+		Event:     ClientConnected,
+		Admission: true,
+	}, &testSessionHandle{})
+
+	var statusErr *StatusError
+	if assert.True(errors.As(err, &statusErr), "should return the backend response") {
+		assert.Equal(http.StatusServiceUnavailable, statusErr.StatusCode)
+		assert.Equal([]byte("try later"), statusErr.Body)
+		assert.Equal("10", UpgradeHeader(statusErr.Header).Get("Retry-After"))
+	}
+	assert.Len(fc.Requests, 1, "rejections should not be retried")
+	assert.Empty(sink.letters, "rejections should not be written to the dead letter sink")
+}
+
+func TestWebhookAdmissionUpgradeHeader(t *testing.T) {
+	assert := assert.New(t)
+	res := fakeResponse(http.StatusOK, nil)
+	res.Header.Add("Set-Cookie", "session=abc")
+	res.Header.Set(UpgradeHeaderPrefix+"X-Tenant", "acme")
+	res.Header.Set(SessionIdHeader, "user-42")
+	fc := fakeHttpClient{Responses: []*http.Response{res}}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+	}
+	sh := testSessionHandle{}
+
+	err := wh.Send(BackendMessage{
+		SessionId: uuid.NewString(),
+		Event:     ClientConnected,
+		Admission: true,
+	}, &sh)
+
+	assert.Nil(err)
+	assert.Equal(http.Header{
+		"Set-Cookie": {"session=abc"},
+		"X-Tenant":   {"acme"},
+	}, sh.upgradeHeader)
+	assert.Equal("user-42", sh.sessionId, "should assign the session ID from the response")
+}
+
+func TestUpgradeHeader(t *testing.T) {
+	header := UpgradeHeader(http.Header{
+		"Content-Type":                             {"text/plain"},
+		"Set-Cookie":                               {"a=1", "b=2"},
+		"Ws-Upgrade-Header-Www-Authenticate":       {"Bearer"},
+		"Ws-Upgrade-Header-Sec-Websocket-Protocol": {"mqtt"},
+		"Ws-Upgrade-Header-Upgrade":                {"h2c"},
+		"Ws-Upgrade-Header-":                       {"empty"},
+	})
+
+	assert.Equal(t, http.Header{
+		"Set-Cookie":       {"a=1", "b=2"},
+		"Www-Authenticate": {"Bearer"},
+	}, header)
+}
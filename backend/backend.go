@@ -4,17 +4,22 @@ package backend
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	metrics "github.com/ws2wh/ws2wh/metrics/directory"
 )
 
 // SessionIdHeader is used to identify the WebSocket session in HTTP headers
+// In a client-connected response sent before the upgrade it assigns the session ID
 const SessionIdHeader = "Ws-Session-Id"
 
 // ReplyChannelHeader contains the URL where webhook responses should be sent
@@ -26,6 +31,28 @@ const EventHeader = "Ws-Event"
 // QueryStringHeader contains the query string from the client
 const QueryStringHeader = "Ws-Query-String"
 
+// ClientIpHeader contains the IP address of the WebSocket client
+// Taken from X-Forwarded-For if the client connected through a trusted proxy
+const ClientIpHeader = "Ws-Client-Ip"
+
+// ForwardedHeaderPrefix prefixes the upgrade request headers forwarded to the backend
+// e.g. the User-Agent header of the client is sent as Ws-Header-User-Agent
+const ForwardedHeaderPrefix = "Ws-Header-"
+
+// ConnectedAtHeader contains the time the WebSocket client connected (RFC 3339, UTC)
+const ConnectedAtHeader = "Ws-Connected-At"
+
+// SubprotocolHeader contains the WebSocket subprotocol negotiated with the client (if any)
+// In a client-connected response sent before the upgrade it selects the subprotocol of the connection
+const SubprotocolHeader = "Ws-Subprotocol"
+
+// RequestedSubprotocolsHeader contains the comma separated subprotocols requested by the client (client-connected events only)
+const RequestedSubprotocolsHeader = "Ws-Requested-Subprotocols"
+
+// MessageTypeHeader contains the WebSocket frame type of the message payload (text or binary)
+// Defaults to text if not provided
+const MessageTypeHeader = "Ws-Message-Type"
+
 // JwtClaimsHeader contains the JWT claims from the client
 const JwtClaimsHeader = "Ws-Session-Jwt-Claims"
 
@@ -34,18 +61,41 @@ const CommandHeader = "Ws-Command"
 
 // CloseCodeHeader contains the close code to use when closing the WebSocket connection
 // Default is 1000
+// On ClientDisconnected events it contains the close code of the connection (if known)
 const CloseCodeHeader = "Ws-Close-Code"
 
 // CloseReasonHeader contains the reason for closing the WebSocket connection
 // Defaults to empty value
+// On ClientDisconnected events it contains the close reason of the connection (if known)
 const CloseReasonHeader = "Ws-Close-Reason"
 
+// CloseOriginHeader indicates who closed the WebSocket connection on ClientDisconnected events (see CloseOrigin)
+const CloseOriginHeader = "Ws-Close-Origin"
+
+// TopicsHeader contains the comma separated topic names for subscribe and unsubscribe commands
+const TopicsHeader = "Ws-Topics"
+
+// SignatureHeader contains the hex encoded HMAC-SHA256 signature of a signed request
+const SignatureHeader = "Ws-Signature"
+
+// TimestampHeader contains the unix time (in seconds) at which a signed request was created
+const TimestampHeader = "Ws-Timestamp"
+
 // SendMessageCommand instructs the server to send a message to the WebSocket client
 const SendMessageCommand = "send-message"
 
 // TerminateSessionCommand instructs the server to close the WebSocket connection
 const TerminateSessionCommand = "terminate-session"
 
+// SubscribeCommand instructs the server to subscribe the session to the topics listed in TopicsHeader
+const SubscribeCommand = "subscribe"
+
+// UnsubscribeCommand instructs the server to unsubscribe the session from the topics listed in TopicsHeader
+const UnsubscribeCommand = "unsubscribe"
+
+// ErrBackendClosed is returned by deliveries whose retries were abandoned because the backend was closed
+var ErrBackendClosed = errors.New("backend closed")
+
 // WsEvent represents different types of WebSocket events that can occur
 type WsEvent int
 
@@ -98,6 +148,65 @@ func ParseWsEvent(e string) WsEvent {
 	}
 }
 
+// MessageType represents the WebSocket data frame type of a message
+type MessageType int
+
+const (
+	// TextMessage denotes a UTF-8 text data frame (RFC 6455 opcode 1)
+	TextMessage MessageType = 1
+	// BinaryMessage denotes a binary data frame (RFC 6455 opcode 2)
+	BinaryMessage MessageType = 2
+)
+
+// String returns the string representation of a MessageType
+// - TextMessage -> "text"
+// - BinaryMessage -> "binary"
+// - Unknown/default -> "unknown"
+func (t MessageType) String() string {
+	switch t {
+	case TextMessage:
+		return "text"
+	case BinaryMessage:
+		return "binary"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseMessageType converts the MessageTypeHeader value to its corresponding MessageType
+// An empty value defaults to TextMessage
+// Returns an error for unknown message types
+func ParseMessageType(headerVal string) (MessageType, error) {
+	switch strings.ToLower(headerVal) {
+	case "", "text":
+		return TextMessage, nil
+	case "binary":
+		return BinaryMessage, nil
+	default:
+		return 0, fmt.Errorf("unknown message type %q", headerVal)
+	}
+}
+
+// CloseOrigin identifies the side that ended a WebSocket session
+type CloseOrigin string
+
+const (
+	// CloseOriginClient denotes a connection closed by the client or lost without a close frame
+	CloseOriginClient CloseOrigin = "client"
+	// CloseOriginBackend denotes a connection closed by the backend with the terminate-session command
+	CloseOriginBackend CloseOrigin = "backend"
+	// CloseOriginTimeout denotes a connection closed by a pong, idle or write timeout
+	CloseOriginTimeout CloseOrigin = "timeout"
+	// CloseOriginServer denotes a connection closed by a server policy, e.g. a full message queue
+	CloseOriginServer CloseOrigin = "server"
+	// CloseOriginShutdown denotes a connection closed because the server is shutting down
+	CloseOriginShutdown CloseOrigin = "server-shutdown"
+	// CloseOriginTokenExpired denotes a connection closed because the JWT of the client expired
+	CloseOriginTokenExpired CloseOrigin = "token-expired"
+	// CloseOriginDuplicate denotes a connection rejected before the upgrade because its assigned session ID is in use
+	CloseOriginDuplicate CloseOrigin = "duplicate-session"
+)
+
 // Backend defines the interface for sending messages to a backend service
 // It provides a single method Send() for delivering messages to the configured backend
 type Backend interface {
@@ -108,14 +217,55 @@ type Backend interface {
 	Send(msg BackendMessage, session SessionHandle) error
 }
 
+// WebhookConfig holds the webhook backend configuration parameters
+type WebhookConfig struct {
+	// SigningSecrets are the secrets used to sign webhook requests (optional)
+	// Every secret produces its own signature, which allows the backend to rotate secrets
+	SigningSecrets []string
+	// Retry holds the delivery retry policy (optional; failed deliveries are not retried by default)
+	Retry *RetryConfig
+	// DeadLetter stores messages abandoned after the last delivery attempt (optional)
+	DeadLetter DeadLetterSink
+	// Client holds the HTTP client parameters (optional)
+	Client *ClientConfig
+	// MaxResponseSize is the maximum size in bytes of a webhook response body read into memory (0 disables the limit)
+	// Larger bodies are truncated one byte past the limit, so they are still rejected as too large by the connection
+	MaxResponseSize int64
+}
+
 // CreateBackend creates a new Backend instance that sends messages via HTTP webhooks
 // url specifies the webhook endpoint URL that will receive the messages
-// Returns a Backend interface using the default HTTP client for making webhook requests
-func CreateBackend(url string) *WebhookBackend {
-	return &WebhookBackend{
+// config holds optional webhook parameters (nil for defaults)
+// Returns a Backend interface using an HTTP client created from the client configuration,
+// or an error if the HTTP client could not be created
+func CreateBackend(url string, config *WebhookConfig) (*WebhookBackend, error) {
+	var clientConfig *ClientConfig
+	if config != nil {
+		clientConfig = config.Client
+	}
+
+	client, err := NewHttpClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &WebhookBackend{
 		url:    url,
-		client: http.DefaultClient,
+		client: client,
+		retry:  newRetryPolicy(nil),
+		done:   make(chan struct{}),
+	}
+
+	if config != nil {
+		b.retry = newRetryPolicy(config.Retry)
+		b.deadLetter = config.DeadLetter
+		b.maxBodySize = config.MaxResponseSize
+		for _, secret := range config.SigningSecrets {
+			b.signingSecrets = append(b.signingSecrets, []byte(secret))
+		}
 	}
+
+	return b, nil
 }
 
 // BackendMessage represents a message to be sent to the backend service
@@ -128,10 +278,31 @@ type BackendMessage struct {
 	Event WsEvent
 	// Payload contains the raw message data bytes
 	Payload []byte
+	// MessageType is the WebSocket frame type of the payload (MessageReceived events only)
+	MessageType MessageType
 	// QueryString contains the query string from the client
 	QueryString string
 	// JwtClaims contains the JWT claims from the client
 	JwtClaims *string
+	// ClientIp is the IP address of the client (empty if not known)
+	ClientIp string
+	// Headers contains the forwarded headers of the client upgrade request
+	Headers http.Header
+	// ConnectedAt is the time the client connected (zero if not sent)
+	ConnectedAt time.Time
+	// Subprotocol is the WebSocket subprotocol negotiated with the client (empty if none)
+	Subprotocol string
+	// RequestedSubprotocols lists the subprotocols requested by the client (ClientConnected events only)
+	RequestedSubprotocols []string
+	// CloseCode is the WebSocket close code (ClientDisconnected events only; 0 if not known)
+	CloseCode int
+	// CloseReason is the WebSocket close reason (ClientDisconnected events only)
+	CloseReason string
+	// CloseOrigin identifies who closed the connection (ClientDisconnected events only; empty if not known)
+	CloseOrigin CloseOrigin
+	// Admission marks a ClientConnected event sent before the upgrade
+	// A non-2xx response rejects the connection and is returned as StatusError without retries
+	Admission bool
 }
 
 type httpClient interface {
@@ -139,20 +310,112 @@ type httpClient interface {
 }
 
 type WebhookBackend struct {
-	url    string
-	client httpClient
+	url            string
+	client         httpClient
+	signingSecrets [][]byte
+	retry          retryPolicy
+	deadLetter     DeadLetterSink
+	maxBodySize    int64
+	doneMu         sync.Mutex
+	done           chan struct{}
+	closeOnce      sync.Once
 }
 
-// Send delivers a message to the configured webhook endpoint
-// It sends the message payload and headers via HTTP POST and handles the response
-// msg contains the message details including session ID, reply channel, event type and payload
-// session provides a handle to send responses back through the WebSocket connection
-// Returns an error if the request fails or receives a non-2xx response
-func (w *WebhookBackend) Send(msg BackendMessage, session SessionHandle) error {
+// Close stops retrying failed deliveries
+// Deliveries waiting for their next attempt return ErrBackendClosed at once and later failed attempts are not retried
+// Abandoned messages are written to the dead letter sink (if configured)
+func (w *WebhookBackend) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closed())
+	})
+	return nil
+}
+
+// closed returns the channel closed by Close
+func (w *WebhookBackend) closed() chan struct{} {
+	w.doneMu.Lock()
+	defer w.doneMu.Unlock()
+	if w.done == nil {
+		w.done = make(chan struct{})
+	}
+	return w.done
+}
+
+// deliver posts the message to the webhook, retrying failed attempts according to the retry policy
+// Attempts are made sequentially by the caller goroutine, so retries never reorder the messages of a session
+// Messages abandoned after the last attempt or on Close are written to the dead letter sink (if configured)
+// Returns the successful response and its body
+func (w *WebhookBackend) deliver(msg BackendMessage) (*http.Response, []byte, error) {
+	firstAttemptAt := time.Now()
+	for attempt := 1; ; attempt++ {
+		req, err := w.newRequest(msg)
+		if err != nil {
+			slog.Error("Error while creating request", "error", err, "sessionId", msg.SessionId)
+			return nil, nil, err
+		}
+
+		res, body, err := w.post(req, msg)
+		if err == nil {
+			metrics.MessageSuccessCounter.With(prometheus.Labels{
+				metrics.OriginLabel: metrics.OriginValueClient,
+			}).Inc()
+
+			return res, body, nil
+		}
+
+		var statusErr *StatusError
+		if msg.Admission && errors.As(err, &statusErr) {
+			// the backend rejected the connection
+			return nil, nil, err
+		}
+
+		metrics.MessageFailureCounter.With(prometheus.Labels{
+			metrics.OriginLabel: metrics.OriginValueClient,
+		}).Inc()
+
+		if !w.retry.shouldRetry(attempt, res) {
+			w.abandon(msg, err, attempt, firstAttemptAt)
+			return nil, nil, err
+		}
+
+		delay := w.retry.backoff(attempt, res)
+		slog.Warn("Retrying delivery to backend", "attempt", attempt, "delay", delay, "error", err, "sessionId", msg.SessionId)
+		metrics.MessageRetryCounter.With(prometheus.Labels{
+			metrics.OriginLabel: metrics.OriginValueClient,
+		}).Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-w.closed():
+			timer.Stop()
+			err = fmt.Errorf("%w, retry abandoned: %w", ErrBackendClosed, err)
+			w.abandon(msg, err, attempt, firstAttemptAt)
+			return nil, nil, err
+		}
+	}
+}
+
+// abandon records the final failure of a message and writes it to the dead letter sink (if configured)
+func (w *WebhookBackend) abandon(msg BackendMessage, err error, attempts int, firstAttemptAt time.Time) {
+	metrics.MessageFinalFailureCounter.With(prometheus.Labels{
+		metrics.OriginLabel: metrics.OriginValueClient,
+	}).Inc()
+
+	if w.deadLetter != nil {
+		dlErr := w.deadLetter.Write(NewDeadLetter(msg, err, attempts, firstAttemptAt))
+		if dlErr != nil {
+			slog.Error("Error while writing dead letter", "error", dlErr, "sessionId", msg.SessionId)
+		}
+	}
+}
+
+// newRequest creates the webhook request for the message
+// Signatures are computed for every attempt, so retried requests carry a fresh timestamp
+func (w *WebhookBackend) newRequest(msg BackendMessage) (*http.Request, error) {
 	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(msg.Payload))
 	if err != nil {
-		slog.Error("Error while creating request", "error", err, "sessionId", msg.SessionId)
-		return err
+		return nil, err
 	}
 
 	h := http.Header{
@@ -165,58 +428,166 @@ func (w *WebhookBackend) Send(msg BackendMessage, session SessionHandle) error {
 		h[QueryStringHeader] = []string{msg.QueryString}
 	}
 
+	if msg.Event == MessageReceived {
+		h[MessageTypeHeader] = []string{msg.MessageType.String()}
+	}
+
 	if msg.JwtClaims != nil {
 		h[JwtClaimsHeader] = []string{*msg.JwtClaims}
 	}
 
+	if msg.ClientIp != "" {
+		h[ClientIpHeader] = []string{msg.ClientIp}
+	}
+
+	if !msg.ConnectedAt.IsZero() {
+		h[ConnectedAtHeader] = []string{msg.ConnectedAt.UTC().Format(time.RFC3339)}
+	}
+
+	if msg.Subprotocol != "" {
+		h[SubprotocolHeader] = []string{msg.Subprotocol}
+	}
+
+	if msg.Event == ClientConnected && len(msg.RequestedSubprotocols) > 0 {
+		h[RequestedSubprotocolsHeader] = []string{strings.Join(msg.RequestedSubprotocols, ", ")}
+	}
+
+	for name, values := range msg.Headers {
+		h[http.CanonicalHeaderKey(ForwardedHeaderPrefix+name)] = values
+	}
+
+	if msg.Event == ClientDisconnected && msg.CloseCode != 0 {
+		h[CloseCodeHeader] = []string{strconv.Itoa(msg.CloseCode)}
+		h[CloseReasonHeader] = []string{msg.CloseReason}
+	}
+
+	if msg.Event == ClientDisconnected && msg.CloseOrigin != "" {
+		h[CloseOriginHeader] = []string{string(msg.CloseOrigin)}
+	}
+
+	if len(w.signingSecrets) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		h[TimestampHeader] = []string{timestamp}
+		h[SignatureHeader] = []string{SignatureHeaderValue(
+			w.signingSecrets,
+			timestamp,
+			[]byte(msg.SessionId),
+			[]byte(msg.Event.String()),
+			msg.Payload,
+		)}
+	}
+
 	req.Header = h
+	return req, nil
+}
 
+// post sends a single delivery attempt
+// Returns the response (nil on transport errors) and its body, or an error if the attempt failed
+func (w *WebhookBackend) post(req *http.Request, msg BackendMessage) (*http.Response, []byte, error) {
 	res, err := w.client.Do(req)
 	if err != nil {
 		slog.Error("Error while sending message to backend", "error", err, "sessionId", msg.SessionId)
-		metrics.MessageFailureCounter.With(prometheus.Labels{
-			metrics.OriginLabel: metrics.OriginValueClient,
-		}).Inc()
-
-		return err
+		return nil, nil, err
 	}
 
 	defer res.Body.Close()
 
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		slog.Error("Unsuccessful delivery to backend", "status", res.StatusCode, "sessionId", msg.SessionId)
-		_, err := io.ReadAll(res.Body)
-		if err != nil {
-			slog.Error("Error while reading response body", "error", err, "sessionId", msg.SessionId)
-			return err
-		}
+	var reader io.Reader = res.Body
+	if w.maxBodySize > 0 {
+		reader = io.LimitReader(res.Body, w.maxBodySize+1)
+	}
 
-		metrics.MessageFailureCounter.With(prometheus.Labels{
-			metrics.OriginLabel: metrics.OriginValueClient,
-		}).Inc()
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		slog.Error("Error while reading response body", "error", err, "sessionId", msg.SessionId)
+		return res, nil, err
+	}
 
-		return fmt.Errorf("unsuccessful delivery to %s", w.url)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		if msg.Admission {
+			slog.Info("Backend rejected connection", "status", res.StatusCode, "sessionId", msg.SessionId)
+		} else {
+			slog.Error("Unsuccessful delivery to backend", "status", res.StatusCode, "sessionId", msg.SessionId)
+		}
+		return res, nil, &StatusError{
+			Url:        w.url,
+			StatusCode: res.StatusCode,
+			Header:     res.Header,
+			Body:       body,
+		}
 	}
 
-	metrics.MessageSuccessCounter.With(prometheus.Labels{
-		metrics.OriginLabel: metrics.OriginValueClient,
-	}).Inc()
+	return res, body, nil
+}
 
-	body, err := io.ReadAll(res.Body)
+// Send delivers a message to the configured webhook endpoint
+// It sends the message payload and headers via HTTP POST and handles the response
+// Failed deliveries are retried according to the configured retry policy
+// msg contains the message details including session ID, reply channel, event type and payload
+// session provides a handle to send responses back through the WebSocket connection
+// Returns an error if the request fails or receives a non-2xx response after the last attempt
+func (w *WebhookBackend) Send(msg BackendMessage, session SessionHandle) error {
+	res, body, err := w.deliver(msg)
 	if err != nil {
-		slog.Error("Error while reading response body", "error", err, "sessionId", msg.SessionId)
 		return err
 	}
 
-	if len(body) > 0 && msg.Event != ClientDisconnected {
-		err = session.Send(body)
+	if msg.Event == ClientConnected {
+		if id := res.Header.Get(SessionIdHeader); id != "" && id != msg.SessionId {
+			if err := session.AssignSessionId(id); err != nil {
+				slog.Warn("Ignoring session ID assigned by the backend", "error", err, "sessionId", msg.SessionId)
+			}
+		}
+
+		if subprotocol := res.Header.Get(SubprotocolHeader); subprotocol != "" {
+			if err := session.SelectSubprotocol(subprotocol); err != nil {
+				slog.Warn("Ignoring subprotocol selected by the backend", "error", err, "sessionId", msg.SessionId)
+			}
+		}
+
+		if header := UpgradeHeader(res.Header); len(header) > 0 {
+			session.SetUpgradeHeader(header)
+		}
+	}
+
+	if msg.Event == ClientDisconnected {
+		// The session is gone, responses to its disconnect carry neither messages nor commands
+		return nil
+	}
+
+	if len(body) > 0 {
+		messageType, err := ParseMessageType(res.Header.Get(MessageTypeHeader))
+		if err != nil {
+			slog.Error("Error while getting message type", "error", err, "sessionId", msg.SessionId)
+			return err
+		}
+
+		err = session.Send(body, messageType)
 		if err != nil {
 			slog.Error("Error while sending response to client", "error", err, "sessionId", msg.SessionId)
 			return err
 		}
 	}
 
-	if res.Header.Get(CommandHeader) == TerminateSessionCommand {
+	switch res.Header.Get(CommandHeader) {
+	case SubscribeCommand, UnsubscribeCommand:
+		topics, err := ParseTopics(res.Header.Get(TopicsHeader))
+		if err != nil {
+			slog.Error("Error while getting topics", "error", err, "sessionId", msg.SessionId)
+			return err
+		}
+
+		if res.Header.Get(CommandHeader) == SubscribeCommand {
+			err = session.Subscribe(topics)
+		} else {
+			err = session.Unsubscribe(topics)
+		}
+
+		if err != nil {
+			slog.Error("Error while updating subscriptions", "error", err, "sessionId", msg.SessionId)
+			return err
+		}
+	case TerminateSessionCommand:
 		closeCode, err := GetCloseCode(res.Header.Get(CloseCodeHeader))
 		if err != nil {
 			slog.Error("Error while getting close code", "error", err, "sessionId", msg.SessionId)
@@ -261,6 +632,23 @@ func GetCloseCode(headerVal string) (int, error) {
 	return int(closeCode), nil
 }
 
+// ParseTopics splits the TopicsHeader value into topic names
+// Returns an error if no topic is listed
+func ParseTopics(headerVal string) ([]string, error) {
+	topics := make([]string, 0)
+	for _, topic := range strings.Split(headerVal, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("at least one topic is required")
+	}
+
+	return topics, nil
+}
+
 func GetCloseReason(headerVal string) (*string, error) {
 	if len(headerVal) > 123 {
 		return nil, fmt.Errorf("close reason must be less than 123 bytes")
@@ -273,12 +661,33 @@ func GetCloseReason(headerVal string) (*string, error) {
 type SessionHandle interface {
 	// Send transmits a message through the WebSocket connection
 	// message is the payload to send to the client
+	// messageType is the WebSocket frame type used for the message
 	// Returns an error if the send fails
-	Send(message []byte) error
+	Send(message []byte, messageType MessageType) error
 
 	// Close terminates the WebSocket session
 	// closeCode is the close code to use when closing the WebSocket connection
 	// closeReason is the reason for closing the WebSocket connection
 	// Returns an error if the close fails
 	Close(closeCode int, closeReason *string) error
+
+	// Subscribe adds the session to the given topics
+	// Returns an error if the subscription fails
+	Subscribe(topics []string) error
+
+	// Unsubscribe removes the session from the given topics
+	// Returns an error if the unsubscription fails
+	Unsubscribe(topics []string) error
+
+	// SelectSubprotocol sets the subprotocol chosen by the backend for the upgrade
+	// Returns an error if the client did not request the subprotocol
+	SelectSubprotocol(protocol string) error
+
+	// SetUpgradeHeader sets the headers added by the backend to the upgrade response
+	// Headers set after the upgrade are ignored
+	SetUpgradeHeader(header http.Header)
+
+	// AssignSessionId replaces the session ID with the ID chosen by the backend
+	// Returns an error if the ID is invalid or can no longer be changed
+	AssignSessionId(id string) error
 }
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -54,6 +55,70 @@ func TestWebhookSuccess(t *testing.T) {
 	assert.Equal(msg.Payload, body, "request body should be same WH message payload")
 }
 
+func TestWebhookClientMetadata(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Body:       io.NopCloser(bytes.NewReader(make([]byte, 0))),
+			},
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+	}
+
+	err := wh.Send(BackendMessage{
+		SessionId:   uuid.NewString(),
+		Event:       MessageReceived,
+		ClientIp:    "203.0.113.7",
+		ConnectedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		Headers: http.Header{
+			"User-Agent": {"test-agent"},
+			"X-Tenant":   {"a", "b"},
+		},
+	}, &testSessionHandle{})
+
+	assert.Nil(err)
+	req := fc.Requests[0]
+	assert.Equal("203.0.113.7", req.Header.Get(ClientIpHeader), "request should contain client ip header")
+	assert.Equal("2024-01-02T02:04:05Z", req.Header.Get(ConnectedAtHeader), "request should contain connected at header in UTC")
+	assert.Equal("test-agent", req.Header.Get("Ws-Header-User-Agent"), "request should contain forwarded header")
+	assert.Equal([]string{"a", "b"}, req.Header.Values("Ws-Header-X-Tenant"), "request should contain all header values")
+}
+
+func TestWebhookSubprotocolSelection(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Header:     http.Header{SubprotocolHeader: {"mqtt"}},
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			},
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+	}
+	sh := testSessionHandle{}
+
+	err := wh.Send(BackendMessage{
+		SessionId:             uuid.NewString(),
+		Event:                 ClientConnected,
+		RequestedSubprotocols: []string{"graphql-ws", "mqtt"},
+	}, &sh)
+
+	assert.Nil(err)
+	assert.Equal("graphql-ws, mqtt", fc.Requests[0].Header.Get(RequestedSubprotocolsHeader), "request should list requested subprotocols")
+	assert.Equal("mqtt", sh.subprotocol, "should select the subprotocol from the response")
+}
+
 func TestWebhookSuccessWithPayload(t *testing.T) {
 	assert := assert.New(t)
 	expectedPayload := []byte(uuid.NewString())
@@ -176,6 +241,117 @@ func TestWebhookTerminateSessionWithCloseHeaders(t *testing.T) {
 	}
 }
 
+func TestWebhookSubscribeCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Header: http.Header{
+					CommandHeader: []string{SubscribeCommand},
+					TopicsHeader:  []string{"news, chat"},
+				},
+				Body: io.NopCloser(bytes.NewReader(nil)),
+			},
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Header: http.Header{
+					CommandHeader: []string{UnsubscribeCommand},
+					TopicsHeader:  []string{"news"},
+				},
+				Body: io.NopCloser(bytes.NewReader(nil)),
+			},
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+	}
+	msg := BackendMessage{
+		SessionId:    uuid.NewString(),
+		ReplyChannel: "http://ws2wh-address/" + uuid.NewString(),
+		Event:        ClientConnected,
+	}
+	sh := testSessionHandle{}
+
+	assert.NoError(wh.Send(msg, &sh))
+	assert.Equal([]string{"news", "chat"}, sh.subscribed)
+
+	assert.NoError(wh.Send(msg, &sh))
+	assert.Equal([]string{"news"}, sh.unsubscribed)
+}
+
+func TestWebhookIgnoresCommandsOnDisconnect(t *testing.T) {
+	assert := assert.New(t)
+
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Header: http.Header{
+					CommandHeader: []string{SubscribeCommand},
+					TopicsHeader:  []string{"news"},
+				},
+				Body: io.NopCloser(bytes.NewReader([]byte("bye"))),
+			},
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+	}
+	msg := BackendMessage{
+		SessionId:    uuid.NewString(),
+		ReplyChannel: "http://ws2wh-address/" + uuid.NewString(),
+		Event:        ClientDisconnected,
+	}
+	sh := testSessionHandle{}
+
+	assert.NoError(wh.Send(msg, &sh))
+	assert.Empty(sh.subscribed)
+	assert.Zero(sh.sendCount)
+}
+
+func TestWebhookMaxResponseSize(t *testing.T) {
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Header:     http.Header{},
+				Body:       io.NopCloser(bytes.NewReader(bytes.Repeat([]byte("x"), 1024))),
+			},
+		},
+	}
+	wh := WebhookBackend{
+		url:         "http://backend/wh/" + uuid.NewString(),
+		client:      &fc,
+		maxBodySize: 4,
+	}
+	msg := BackendMessage{
+		SessionId:    uuid.NewString(),
+		ReplyChannel: "http://ws2wh-address/" + uuid.NewString(),
+		Event:        MessageReceived,
+	}
+	sh := testSessionHandle{}
+
+	assert.NoError(t, wh.Send(msg, &sh))
+	assert.Equal(t, []byte("xxxxx"), sh.lastPayload, "bodies should be read one byte past the limit")
+}
+
+func TestParseTopics(t *testing.T) {
+	topics, err := ParseTopics(" a ,b,,c ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, topics)
+
+	_, err = ParseTopics(" , ")
+	assert.Error(t, err)
+}
+
 func TestGetCloseCode(t *testing.T) {
 	validHeaderVals := []string{
 		"1001",
@@ -263,14 +439,21 @@ func (c *fakeHttpClient) Do(req *http.Request) (*http.Response, error) {
 
 type testSessionHandle struct {
 	lastPayload     []byte
+	lastMessageType MessageType
 	sendCount       int
 	closeCount      int
 	lastCloseCode   int
 	lastCloseReason *string
+	subscribed      []string
+	unsubscribed    []string
+	subprotocol     string
+	upgradeHeader   http.Header
+	sessionId       string
 }
 
-func (s *testSessionHandle) Send(payload []byte) error {
+func (s *testSessionHandle) Send(payload []byte, messageType MessageType) error {
 	s.lastPayload = payload
+	s.lastMessageType = messageType
 	s.sendCount += 1
 	return nil
 }
@@ -280,3 +463,25 @@ func (s *testSessionHandle) Close(closeCode int, closeReason *string) error {
 	s.lastCloseReason = closeReason
 	return nil
 }
+func (s *testSessionHandle) Subscribe(topics []string) error {
+	s.subscribed = append(s.subscribed, topics...)
+	return nil
+}
+func (s *testSessionHandle) Unsubscribe(topics []string) error {
+	s.unsubscribed = append(s.unsubscribed, topics...)
+	return nil
+}
+
+func (s *testSessionHandle) SelectSubprotocol(protocol string) error {
+	s.subprotocol = protocol
+	return nil
+}
+
+func (s *testSessionHandle) SetUpgradeHeader(header http.Header) {
+	s.upgradeHeader = header
+}
+
+func (s *testSessionHandle) AssignSessionId(id string) error {
+	s.sessionId = id
+	return nil
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultRequestTimeout      = 30 * time.Second
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConns        = 100
+)
+
+// ClientConfig holds the parameters of the HTTP client used to call the webhook backend
+// Zero values are replaced by defaults
+type ClientConfig struct {
+	// Timeout is the maximum duration of a single webhook request, including reading the response (default: 30s)
+	Timeout time.Duration
+	// DialTimeout is the maximum duration of establishing a TCP connection (default: 10s)
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout is the maximum duration of the TLS handshake (default: 10s)
+	TLSHandshakeTimeout time.Duration
+	// IdleConnTimeout is the time an idle keep-alive connection stays in the pool (default: 90s)
+	IdleConnTimeout time.Duration
+	// MaxIdleConns is the maximum number of idle connections in the pool (default: 100)
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections to the backend host (default: 100)
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the number of connections to the backend host (default: 0, unlimited)
+	MaxConnsPerHost int
+	// DisableHTTP2 restricts the client to HTTP/1.1 (default: false)
+	DisableHTTP2 bool
+	// ProxyUrl is the URL of the HTTP proxy used for webhook requests (default: HTTP_PROXY and HTTPS_PROXY variables)
+	ProxyUrl string
+	// CaBundlePath is the path of PEM encoded CA certificates trusted in addition to the system pool (optional)
+	CaBundlePath string
+	// ClientCertPath is the path of the PEM encoded client certificate for mTLS (optional)
+	ClientCertPath string
+	// ClientKeyPath is the path of the PEM encoded client certificate private key for mTLS (optional)
+	ClientKeyPath string
+}
+
+// NewHttpClient creates the HTTP client used to call the webhook backend
+// config holds the client parameters (nil for defaults)
+// Returns an error if the proxy URL, CA bundle or client certificate is invalid
+func NewHttpClient(config *ClientConfig) (*http.Client, error) {
+	c := ClientConfig{}
+	if config != nil {
+		c = *config
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   durationOrDefault(c.DialTimeout, defaultDialTimeout),
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+		TLSHandshakeTimeout:   durationOrDefault(c.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		IdleConnTimeout:       durationOrDefault(c.IdleConnTimeout, defaultIdleConnTimeout),
+		MaxIdleConns:          intOrDefault(c.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   intOrDefault(c.MaxIdleConnsPerHost, defaultMaxIdleConns),
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	if c.DisableHTTP2 {
+		// a non-nil empty map disables the HTTP/2 upgrade of TLS connections
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	if c.ProxyUrl != "" {
+		proxyUrl, err := url.Parse(c.ProxyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	if c.CaBundlePath != "" {
+		pem, err := os.ReadFile(c.CaBundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backend CA bundle: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in backend CA bundle %s", c.CaBundlePath)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if c.ClientCertPath != "" || c.ClientKeyPath != "" {
+		if c.ClientCertPath == "" || c.ClientKeyPath == "" {
+			return nil, fmt.Errorf("both backend client certificate and key paths are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(c.ClientCertPath, c.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend client certificate: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   durationOrDefault(c.Timeout, defaultRequestTimeout),
+	}, nil
+}
+
+func durationOrDefault(value, fallback time.Duration) time.Duration {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
+
+func intOrDefault(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,162 @@
+package backend
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttpClientCaBundle(t *testing.T) {
+	assert := assert.New(t)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client, err := NewHttpClient(nil)
+	assert.NoError(err)
+	_, err = client.Get(srv.URL)
+	assert.Error(err, "should not trust unknown CA")
+
+	caPath := writePem(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	client, err = NewHttpClient(&ClientConfig{CaBundlePath: caPath})
+	assert.NoError(err)
+	res, err := client.Get(srv.URL)
+	if assert.NoError(err, "should trust CA from bundle") {
+		res.Body.Close()
+		assert.Equal(http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestHttpClientMutualTls(t *testing.T) {
+	assert := assert.New(t)
+	certDer, key := generateCertificate(t)
+	clientCert, err := x509.ParseCertificate(certDer)
+	assert.NoError(err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caPath := writePem(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	client, err := NewHttpClient(&ClientConfig{CaBundlePath: caPath})
+	assert.NoError(err)
+	_, err = client.Get(srv.URL)
+	assert.Error(err, "should be rejected without client certificate")
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(err)
+	client, err = NewHttpClient(&ClientConfig{
+		CaBundlePath:   caPath,
+		ClientCertPath: writePem(t, "client.pem", "CERTIFICATE", certDer),
+		ClientKeyPath:  writePem(t, "client-key.pem", "EC PRIVATE KEY", keyDer),
+	})
+	assert.NoError(err)
+	res, err := client.Get(srv.URL)
+	if assert.NoError(err, "should be accepted with client certificate") {
+		res.Body.Close()
+		assert.Equal(http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestHttpClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client, err := NewHttpClient(&ClientConfig{Timeout: 50 * time.Millisecond})
+	assert.NoError(t, err)
+
+	start := time.Now()
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err, "should time out on hung backend")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestHttpClientDisableHttp2(t *testing.T) {
+	assert := assert.New(t)
+	protocols := make(chan int, 1)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols <- r.ProtoMajor
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	caPath := writePem(t, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+
+	for _, disabled := range []bool{false, true} {
+		client, err := NewHttpClient(&ClientConfig{CaBundlePath: caPath, DisableHTTP2: disabled})
+		assert.NoError(err)
+		res, err := client.Get(srv.URL)
+		if assert.NoError(err) {
+			res.Body.Close()
+		}
+
+		if disabled {
+			assert.Equal(1, <-protocols, "should use HTTP/1.1 when HTTP/2 is disabled")
+		} else {
+			assert.Equal(2, <-protocols, "should negotiate HTTP/2 by default")
+		}
+	}
+}
+
+func TestHttpClientInvalidConfig(t *testing.T) {
+	_, err := NewHttpClient(&ClientConfig{CaBundlePath: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+
+	_, err = NewHttpClient(&ClientConfig{CaBundlePath: writePem(t, "empty.pem", "NOTHING", []byte("x"))})
+	assert.Error(t, err)
+
+	_, err = NewHttpClient(&ClientConfig{ClientCertPath: "client.pem"})
+	assert.Error(t, err, "should require both certificate and key")
+
+	_, err = NewHttpClient(&ClientConfig{ProxyUrl: "://proxy"})
+	assert.Error(t, err)
+}
+
+func writePem(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	assert.NoError(t, err)
+	return path
+}
+
+func generateCertificate(t *testing.T) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ws2wh-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	return der, key
+}
@@ -0,0 +1,244 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter is the record of a message that could not be delivered to the backend
+type DeadLetter struct {
+	// SessionId identifies the WebSocket session the message belongs to
+	SessionId string `json:"sessionId"`
+	// ReplyChannel is the reply URL of the session
+	ReplyChannel string `json:"replyChannel"`
+	// Event is the name of the WebSocket event (see WsEvent)
+	Event string `json:"event"`
+	// Payload contains the raw message data bytes (base64 encoded in JSON)
+	Payload []byte `json:"payload"`
+	// MessageType is the WebSocket frame type of the payload (message-received events only)
+	MessageType string `json:"messageType,omitempty"`
+	// QueryString contains the query string from the client
+	QueryString string `json:"queryString,omitempty"`
+	// JwtClaims contains the JWT claims from the client
+	JwtClaims *string `json:"jwtClaims,omitempty"`
+	// ClientIp is the IP address of the client
+	ClientIp string `json:"clientIp,omitempty"`
+	// Headers contains the forwarded headers of the client upgrade request
+	Headers http.Header `json:"headers,omitempty"`
+	// ConnectedAt is the time the client connected
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	// Subprotocol is the WebSocket subprotocol negotiated with the client
+	Subprotocol string `json:"subprotocol,omitempty"`
+	// RequestedSubprotocols lists the subprotocols requested by the client (client-connected events only)
+	RequestedSubprotocols []string `json:"requestedSubprotocols,omitempty"`
+	// CloseCode is the WebSocket close code (client-disconnected events only)
+	CloseCode int `json:"closeCode,omitempty"`
+	// CloseReason is the WebSocket close reason (client-disconnected events only)
+	CloseReason string `json:"closeReason,omitempty"`
+	// CloseOrigin identifies who closed the connection (client-disconnected events only)
+	CloseOrigin string `json:"closeOrigin,omitempty"`
+	// Error is the error of the last delivery attempt
+	Error string `json:"error"`
+	// Attempts is the number of delivery attempts made
+	Attempts int `json:"attempts"`
+	// FirstAttemptAt is the time of the first delivery attempt
+	FirstAttemptAt time.Time `json:"firstAttemptAt"`
+	// FailedAt is the time the message was abandoned
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// NewDeadLetter creates the dead letter record of an undeliverable message
+func NewDeadLetter(msg BackendMessage, err error, attempts int, firstAttemptAt time.Time) DeadLetter {
+	letter := DeadLetter{
+		SessionId:             msg.SessionId,
+		ReplyChannel:          msg.ReplyChannel,
+		Event:                 msg.Event.String(),
+		Payload:               msg.Payload,
+		QueryString:           msg.QueryString,
+		JwtClaims:             msg.JwtClaims,
+		ClientIp:              msg.ClientIp,
+		Headers:               msg.Headers,
+		Subprotocol:           msg.Subprotocol,
+		RequestedSubprotocols: msg.RequestedSubprotocols,
+		CloseCode:             msg.CloseCode,
+		CloseReason:           msg.CloseReason,
+		CloseOrigin:           string(msg.CloseOrigin),
+		Attempts:              attempts,
+		FirstAttemptAt:        firstAttemptAt.UTC(),
+		FailedAt:              time.Now().UTC(),
+	}
+
+	if msg.Event == MessageReceived {
+		letter.MessageType = msg.MessageType.String()
+	}
+
+	if !msg.ConnectedAt.IsZero() {
+		connectedAt := msg.ConnectedAt.UTC()
+		letter.ConnectedAt = &connectedAt
+	}
+
+	if err != nil {
+		letter.Error = err.Error()
+	}
+
+	return letter
+}
+
+// Message converts the dead letter back to the original backend message
+// Returns an error if the record contains an unknown event or message type
+func (d *DeadLetter) Message() (BackendMessage, error) {
+	event := ParseWsEvent(d.Event)
+	if event == Unknown {
+		return BackendMessage{}, fmt.Errorf("unknown event %q", d.Event)
+	}
+
+	msg := BackendMessage{
+		SessionId:             d.SessionId,
+		ReplyChannel:          d.ReplyChannel,
+		Event:                 event,
+		Payload:               d.Payload,
+		QueryString:           d.QueryString,
+		JwtClaims:             d.JwtClaims,
+		ClientIp:              d.ClientIp,
+		Headers:               d.Headers,
+		Subprotocol:           d.Subprotocol,
+		RequestedSubprotocols: d.RequestedSubprotocols,
+		CloseCode:             d.CloseCode,
+		CloseReason:           d.CloseReason,
+		CloseOrigin:           CloseOrigin(d.CloseOrigin),
+	}
+
+	if d.ConnectedAt != nil {
+		msg.ConnectedAt = *d.ConnectedAt
+	}
+
+	if event == MessageReceived {
+		messageType, err := ParseMessageType(d.MessageType)
+		if err != nil {
+			return BackendMessage{}, err
+		}
+		msg.MessageType = messageType
+	}
+
+	if msg.Payload == nil {
+		msg.Payload = make([]byte, 0)
+	}
+
+	return msg, nil
+}
+
+// DeadLetterSink stores messages that could not be delivered to the backend
+type DeadLetterSink interface {
+	// Write stores the dead letter
+	// Returns an error if the record could not be stored
+	Write(letter DeadLetter) error
+}
+
+// FileDeadLetterSink appends dead letters to a file, one JSON object per line
+type FileDeadLetterSink struct {
+	// FilePath is the path of the dead letter file, created if it does not exist
+	FilePath string
+	lock     sync.Mutex
+}
+
+// Write appends the dead letter to the file
+// The file is opened for every record, so it can be rotated or removed at any time
+func (s *FileDeadLetterSink) Write(letter DeadLetter) error {
+	line, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := os.OpenFile(s.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	return errors.Join(err, f.Close())
+}
+
+// defaultDeadLetterTimeout is the maximum duration of a dead letter request without a configured client
+const defaultDeadLetterTimeout = 30 * time.Second
+
+// HttpDeadLetterSink forwards dead letters to an HTTP endpoint as JSON POST requests
+// Dead letters are written on the delivery goroutine of the session, so every request is bounded by a timeout
+type HttpDeadLetterSink struct {
+	// URL is the endpoint receiving the dead letters
+	URL string
+	// Timeout is the maximum duration of a dead letter request, ignored if Client is set (default: 30s)
+	Timeout time.Duration
+	// Client is the HTTP client used to forward dead letters (default: a client with Timeout)
+	Client *http.Client
+
+	clientOnce    sync.Once
+	defaultClient *http.Client
+}
+
+// Write posts the dead letter to the configured URL
+// Returns an error if the request fails or receives a non-2xx response
+func (s *HttpDeadLetterSink) Write(letter DeadLetter) error {
+	body, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client().Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unsuccessful dead letter delivery to %s: %s", s.URL, res.Status)
+	}
+
+	return nil
+}
+
+func (s *HttpDeadLetterSink) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+
+	s.clientOnce.Do(func() {
+		timeout := s.Timeout
+		if timeout <= 0 {
+			timeout = defaultDeadLetterTimeout
+		}
+		s.defaultClient = &http.Client{Timeout: timeout}
+	})
+	return s.defaultClient
+}
+
+// ReadDeadLetters decodes the JSON lines written by FileDeadLetterSink
+// fn is called for every record in order, reading stops at the first error returned by fn
+// Returns an error if the input is not a valid dead letter stream or fn fails
+func ReadDeadLetters(r io.Reader, fn func(letter DeadLetter) error) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var letter DeadLetter
+		err := decoder.Decode(&letter)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(letter); err != nil {
+			return err
+		}
+	}
+}
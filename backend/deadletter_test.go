@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookWritesDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			fakeResponse(http.StatusServiceUnavailable, nil),
+			fakeResponse(http.StatusServiceUnavailable, nil),
+		},
+	}
+	sink := &testDeadLetterSink{}
+	wh := WebhookBackend{
+		url:        "http://backend/wh/" + uuid.NewString(),
+		client:     &fc,
+		retry:      newRetryPolicy(&RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		deadLetter: sink,
+	}
+	claims := `{"sub":"user"}`
+	msg := BackendMessage{
+		SessionId:    uuid.NewString(),
+		ReplyChannel: "http://ws2wh-address/" + uuid.NewString(),
+		Event:        MessageReceived,
+		Payload:      []byte{0x00, 0xff},
+		MessageType:  BinaryMessage,
+		QueryString:  "a=b",
+		JwtClaims:    &claims,
+	}
+
+	err := wh.Send(msg, &testSessionHandle{})
+
+	assert.NotNil(err)
+	if assert.Len(sink.letters, 1, "should write dead letter after the last attempt") {
+		letter := sink.letters[0]
+		assert.Equal(2, letter.Attempts)
+		assert.Equal(err.Error(), letter.Error)
+		assert.False(letter.FailedAt.Before(letter.FirstAttemptAt))
+
+		replayed, err := letter.Message()
+		assert.Nil(err)
+		assert.Equal(msg, replayed, "dead letter should restore the original message")
+	}
+}
+
+func TestWebhookSuccessSkipsDeadLetter(t *testing.T) {
+	sink := &testDeadLetterSink{}
+	wh := WebhookBackend{
+		url:        "http://backend/wh/" + uuid.NewString(),
+		client:     &fakeHttpClient{Responses: []*http.Response{fakeResponse(http.StatusOK, nil)}},
+		deadLetter: sink,
+	}
+
+	err := wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: ClientConnected}, &testSessionHandle{})
+
+	assert.Nil(t, err)
+	assert.Empty(t, sink.letters)
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	sink := &FileDeadLetterSink{FilePath: path}
+
+	first := NewDeadLetter(BackendMessage{SessionId: "a", Event: ClientConnected}, errors.New("refused"), 1, time.Now())
+	second := NewDeadLetter(BackendMessage{SessionId: "b", Event: MessageReceived, Payload: []byte("hi"), MessageType: TextMessage}, errors.New("timeout"), 3, time.Now())
+	assert.Nil(sink.Write(first))
+	assert.Nil(sink.Write(second))
+
+	f, err := os.Open(path)
+	if !assert.Nil(err) {
+		return
+	}
+	defer f.Close()
+
+	letters := make([]DeadLetter, 0)
+	err = ReadDeadLetters(f, func(letter DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	})
+
+	assert.Nil(err)
+	if assert.Len(letters, 2) {
+		assert.Equal("a", letters[0].SessionId)
+		assert.Equal("refused", letters[0].Error)
+		assert.Equal("b", letters[1].SessionId)
+		assert.Equal([]byte("hi"), letters[1].Payload)
+		assert.Equal("text", letters[1].MessageType)
+	}
+}
+
+func TestHttpDeadLetterSink(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan DeadLetter, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var letter DeadLetter
+		if err := json.NewDecoder(r.Body).Decode(&letter); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- letter
+	}))
+	defer srv.Close()
+
+	sink := &HttpDeadLetterSink{URL: srv.URL}
+	err := sink.Write(NewDeadLetter(BackendMessage{SessionId: "a", Event: ClientDisconnected}, errors.New("refused"), 1, time.Now()))
+
+	assert.Nil(err)
+	letter := <-received
+	assert.Equal("a", letter.SessionId)
+	assert.Equal("client-disconnected", letter.Event)
+
+	sink.URL = srv.URL + "/missing"
+	assert.NotNil(sink.Write(letter), "should fail on non-2xx response")
+}
+
+func TestHttpDeadLetterSinkTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	sink := &HttpDeadLetterSink{URL: srv.URL, Timeout: 50 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Write(NewDeadLetter(BackendMessage{SessionId: "a", Event: ClientDisconnected}, errors.New("refused"), 1, time.Now()))
+	}()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err, "unanswered dead letters should time out")
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "dead letter write should not block")
+	}
+}
+
+func TestDeadLetterInvalidEvent(t *testing.T) {
+	letter := DeadLetter{SessionId: "a", Event: "unknown"}
+	_, err := letter.Message()
+	assert.NotNil(t, err)
+}
+
+type testDeadLetterSink struct {
+	letters []DeadLetter
+}
+
+func (s *testDeadLetterSink) Write(letter DeadLetter) error {
+	s.letters = append(s.letters, letter)
+	return nil
+}
@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+)
+
+// DefaultRetryableStatuses lists the response status codes retried when RetryConfig.RetryableStatuses is empty
+var DefaultRetryableStatuses = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryConfig holds the webhook delivery retry policy
+// Transport errors and responses with one of the RetryableStatuses are retried
+// with exponential backoff until MaxAttempts is reached
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of delivery attempts, including the first one (default: 1, no retries)
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled on every following retry (default: 200ms)
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum delay between attempts, also caps Retry-After values (default: 10s)
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay that is randomized, between 0 and 1 (default: 0)
+	Jitter float64
+	// RetryableStatuses lists the response status codes that are retried (default: DefaultRetryableStatuses)
+	RetryableStatuses []int
+}
+
+// retryPolicy is the RetryConfig with defaults applied
+type retryPolicy struct {
+	maxAttempts       int
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	jitter            float64
+	retryableStatuses map[int]bool
+}
+
+func newRetryPolicy(config *RetryConfig) retryPolicy {
+	p := retryPolicy{
+		maxAttempts:    1,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+
+	if config == nil {
+		return p
+	}
+
+	if config.MaxAttempts > 1 {
+		p.maxAttempts = config.MaxAttempts
+	}
+	if config.InitialBackoff > 0 {
+		p.initialBackoff = config.InitialBackoff
+	}
+	if config.MaxBackoff > 0 {
+		p.maxBackoff = config.MaxBackoff
+	}
+	p.jitter = math.Min(math.Max(config.Jitter, 0), 1)
+
+	statuses := config.RetryableStatuses
+	if len(statuses) == 0 {
+		statuses = DefaultRetryableStatuses
+	}
+	p.retryableStatuses = make(map[int]bool, len(statuses))
+	for _, status := range statuses {
+		p.retryableStatuses[status] = true
+	}
+
+	return p
+}
+
+// shouldRetry decides whether a failed attempt is retried
+// res is nil when the attempt failed with a transport error
+func (p *retryPolicy) shouldRetry(attempt int, res *http.Response) bool {
+	if attempt >= p.maxAttempts {
+		return false
+	}
+
+	return res == nil || p.retryableStatuses[res.StatusCode]
+}
+
+// backoff returns the delay before the next attempt
+// A valid Retry-After header of the failed response takes precedence over the exponential backoff
+func (p *retryPolicy) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if delay, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now()); ok {
+			return min(delay, p.maxBackoff)
+		}
+	}
+
+	delay := p.initialBackoff
+	for i := 1; i < attempt && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.maxBackoff)
+
+	if p.jitter > 0 {
+		spread := float64(delay) * p.jitter
+		delay = time.Duration(float64(delay) - spread + rand.Float64()*2*spread)
+	}
+
+	return delay
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+// Returns false if the header is missing or invalid
+func parseRetryAfter(headerVal string, now time.Time) (time.Duration, bool) {
+	if headerVal == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(headerVal); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(headerVal)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
@@ -0,0 +1,207 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookRetrySuccess(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			fakeResponse(http.StatusServiceUnavailable, nil),
+			fakeResponse(http.StatusTooManyRequests, nil),
+			fakeResponse(http.StatusOK, []byte("reply")),
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+		retry:  newRetryPolicy(&RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+	}
+	msg := BackendMessage{
+		SessionId: uuid.NewString(),
+		Event:     MessageReceived,
+		Payload:   []byte(uuid.NewString()),
+	}
+
+	sessionHandle := testSessionHandle{}
+	err := wh.Send(msg, &sessionHandle)
+
+	assert.Nil(err)
+	assert.Len(fc.Requests, 3, "should retry until success")
+	for _, req := range fc.Requests {
+		body, err := io.ReadAll(req.Body)
+		assert.Nil(err)
+		assert.Equal(msg.Payload, body, "every attempt should send the full payload")
+	}
+	assert.Equal([]byte("reply"), sessionHandle.lastPayload)
+}
+
+func TestWebhookRetryExhausted(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			fakeResponse(http.StatusBadGateway, nil),
+			fakeResponse(http.StatusBadGateway, nil),
+			fakeResponse(http.StatusOK, nil),
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+		retry:  newRetryPolicy(&RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+	}
+
+	err := wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: MessageReceived}, &testSessionHandle{})
+
+	assert.NotNil(err)
+	assert.Len(fc.Requests, 2, "should stop after max attempts")
+}
+
+func TestWebhookRetryNonRetryableStatus(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			fakeResponse(http.StatusBadRequest, nil),
+			fakeResponse(http.StatusOK, nil),
+		},
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+		retry:  newRetryPolicy(&RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+	}
+
+	err := wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: MessageReceived}, &testSessionHandle{})
+
+	assert.NotNil(err)
+	assert.Len(fc.Requests, 1, "should not retry non-retryable status")
+}
+
+func TestWebhookRetryTransportError(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		// sends error if no responses in the queue
+		Responses: make([]*http.Response, 0),
+	}
+	wh := WebhookBackend{
+		url:    "http://backend/wh/" + uuid.NewString(),
+		client: &fc,
+		retry:  newRetryPolicy(&RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+	}
+
+	err := wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: MessageReceived}, &testSessionHandle{})
+
+	assert.NotNil(err)
+	assert.Len(fc.Requests, 3, "should retry transport errors")
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+	p := newRetryPolicy(&RetryConfig{
+		MaxAttempts:    10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	})
+
+	assert.Equal(100*time.Millisecond, p.backoff(1, nil))
+	assert.Equal(200*time.Millisecond, p.backoff(2, nil))
+	assert.Equal(400*time.Millisecond, p.backoff(3, nil))
+	assert.Equal(time.Second, p.backoff(5, nil), "should be capped by max backoff")
+	assert.Equal(time.Second, p.backoff(1000, nil), "should not overflow")
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := p.backoff(2, nil)
+		assert.GreaterOrEqual(delay, 100*time.Millisecond)
+		assert.LessOrEqual(delay, 300*time.Millisecond)
+	}
+}
+
+func TestRetryBackoffRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	p := newRetryPolicy(&RetryConfig{MaxAttempts: 3, MaxBackoff: 5 * time.Second})
+
+	res := fakeResponse(http.StatusTooManyRequests, nil)
+	res.Header.Set("Retry-After", "2")
+	assert.Equal(2*time.Second, p.backoff(1, res))
+
+	res.Header.Set("Retry-After", "120")
+	assert.Equal(5*time.Second, p.backoff(1, res), "should be capped by max backoff")
+
+	res.Header.Set("Retry-After", "soon")
+	assert.Equal(defaultInitialBackoff, p.backoff(1, res), "should ignore invalid value")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	delay, ok := parseRetryAfter("30", now)
+	assert.True(ok)
+	assert.Equal(30*time.Second, delay)
+
+	delay, ok = parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now)
+	assert.True(ok)
+	assert.Equal(time.Minute, delay)
+
+	delay, ok = parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now)
+	assert.True(ok)
+	assert.Zero(delay, "past date should retry immediately")
+
+	_, ok = parseRetryAfter("", now)
+	assert.False(ok)
+	_, ok = parseRetryAfter("-1", now)
+	assert.False(ok)
+}
+
+func fakeResponse(status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+func TestWebhookCloseInterruptsBackoff(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			fakeResponse(http.StatusServiceUnavailable, nil),
+			fakeResponse(http.StatusOK, nil),
+		},
+	}
+	sink := &testDeadLetterSink{}
+	wh := WebhookBackend{
+		url:        "http://backend/wh/" + uuid.NewString(),
+		client:     &fc,
+		retry:      newRetryPolicy(&RetryConfig{MaxAttempts: 3, InitialBackoff: time.Minute, MaxBackoff: time.Minute}),
+		deadLetter: sink,
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: MessageReceived}, &testSessionHandle{})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(wh.Close())
+	assert.Nil(wh.Close(), "closing twice should not fail")
+
+	select {
+	case err := <-result:
+		assert.ErrorIs(err, ErrBackendClosed)
+		assert.Len(fc.Requests, 1, "should not retry after close")
+		assert.Len(sink.letters, 1, "should write dead letter of the abandoned message")
+	case <-time.After(time.Second):
+		t.Fatal("close should interrupt the backoff")
+	}
+}
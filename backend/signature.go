@@ -0,0 +1,146 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissingSignature is returned when a request has no signature or timestamp header
+	ErrMissingSignature = errors.New("missing signature")
+	// ErrInvalidSignature is returned when no signature matches any of the accepted secrets
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrSignatureExpired is returned when the signature timestamp is outside of the allowed tolerance
+	ErrSignatureExpired = errors.New("signature expired")
+)
+
+// ComputeSignature calculates the hex encoded HMAC-SHA256 signature of a request
+// secret is the shared secret used as the HMAC key
+// timestamp is the value sent in the TimestampHeader
+// parts are the signed request elements
+// The timestamp and every part are length-prefixed as "<decimal byte length>:<bytes>", so parts may contain any byte
+// and different part lists never produce the same signed input
+func ComputeSignature(secret []byte, timestamp string, parts ...[]byte) string {
+	mac := hmac.New(sha256.New, secret)
+	writeSignedPart(mac, []byte(timestamp))
+	for _, part := range parts {
+		writeSignedPart(mac, part)
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func writeSignedPart(w io.Writer, part []byte) {
+	io.WriteString(w, strconv.Itoa(len(part)))
+	io.WriteString(w, ":")
+	w.Write(part)
+}
+
+// ReplyControlHeaders are the reply channel headers covered by reply request signatures, in canonical order
+var ReplyControlHeaders = []string{CommandHeader, CloseCodeHeader, CloseReasonHeader, TopicsHeader, MessageTypeHeader}
+
+// CanonicalHeaders returns the canonical form of the given headers, one "<lowercase name>:<trimmed value>\n" line
+// per header in the given order. Missing headers are included with an empty value
+func CanonicalHeaders(header http.Header, names []string) []byte {
+	var b bytes.Buffer
+	for _, name := range names {
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte(':')
+		b.WriteString(strings.TrimSpace(header.Get(name)))
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes()
+}
+
+// ReplySignatureParts returns the signed parts of a reply channel request:
+// the HTTP method, the request path, the canonical ReplyControlHeaders and the request body
+func ReplySignatureParts(method string, path string, header http.Header, body []byte) [][]byte {
+	return [][]byte{
+		[]byte(strings.ToUpper(method)),
+		[]byte(path),
+		CanonicalHeaders(header, ReplyControlHeaders),
+		body,
+	}
+}
+
+// SignatureHeaderValue calculates signatures with each of the secrets and joins them
+// into a single SignatureHeader value. Multiple signatures allow the receiving side
+// to rotate secrets without downtime.
+func SignatureHeaderValue(secrets [][]byte, timestamp string, parts ...[]byte) string {
+	signatures := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		signatures = append(signatures, ComputeSignature(secret, timestamp, parts...))
+	}
+
+	return strings.Join(signatures, ",")
+}
+
+// VerifySignature checks that at least one of the comma separated signatures
+// matches a signature computed with any of the accepted secrets
+// tolerance is the maximum allowed difference between the timestamp and the current time
+// Returns nil if the signature is valid
+func VerifySignature(secrets [][]byte, tolerance time.Duration, signatureHeader string, timestamp string, parts ...[]byte) error {
+	if signatureHeader == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrSignatureExpired
+	}
+
+	for _, secret := range secrets {
+		expected := ComputeSignature(secret, timestamp, parts...)
+		for _, signature := range strings.Split(signatureHeader, ",") {
+			if hmac.Equal([]byte(expected), []byte(strings.TrimSpace(signature))) {
+				return nil
+			}
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// VerifyRequest checks the signature of a webhook request sent by ws2wh
+// It is meant to be used by Go backends receiving ws2wh webhooks
+// secrets are the accepted signing secrets (any of them may match)
+// tolerance is the maximum allowed age of the request timestamp
+// The request body is restored after reading, so it can be read again by the caller
+// Returns nil if the request signature is valid
+func VerifyRequest(r *http.Request, secrets []string, tolerance time.Duration) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	keys := make([][]byte, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, []byte(secret))
+	}
+
+	return VerifySignature(
+		keys,
+		tolerance,
+		r.Header.Get(SignatureHeader),
+		r.Header.Get(TimestampHeader),
+		[]byte(r.Header.Get(SessionIdHeader)),
+		[]byte(r.Header.Get(EventHeader)),
+		body,
+	)
+}
@@ -0,0 +1,124 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSignedRequest(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Body:       io.NopCloser(bytes.NewReader(make([]byte, 0))),
+			},
+		},
+	}
+	wh, err := CreateBackend("http://backend/wh/"+uuid.NewString(), &WebhookConfig{
+		SigningSecrets: []string{"new-secret", "old-secret"},
+	})
+	assert.NoError(err)
+	wh.client = &fc
+
+	msg := BackendMessage{
+		SessionId:    uuid.NewString(),
+		ReplyChannel: "http://ws2wh-address/" + uuid.NewString(),
+		Event:        MessageReceived,
+		Payload:      []byte(uuid.NewString()),
+	}
+
+	err = wh.Send(msg, &testSessionHandle{})
+	assert.NoError(err)
+	assert.Len(fc.Requests, 1, "should receive 1 request")
+
+	req := fc.Requests[0]
+	assert.NotEmpty(req.Header.Get(TimestampHeader), "request should contain timestamp header")
+	assert.Len(strings.Split(req.Header.Get(SignatureHeader), ","), 2, "request should be signed with every secret")
+
+	assert.NoError(VerifyRequest(req, []string{"new-secret"}, time.Minute), "should verify with current secret")
+	assert.NoError(VerifyRequest(req, []string{"old-secret"}, time.Minute), "should verify with rotated secret")
+	assert.ErrorIs(VerifyRequest(req, []string{"other-secret"}, time.Minute), ErrInvalidSignature)
+
+	body, err := io.ReadAll(req.Body)
+	assert.NoError(err)
+	assert.Equal(msg.Payload, body, "request body should be readable after verification")
+}
+
+func TestWebhookUnsignedRequest(t *testing.T) {
+	assert := assert.New(t)
+	fc := fakeHttpClient{
+		Responses: []*http.Response{
+			{
+				StatusCode: http.StatusOK,
+				Status:     http.StatusText(200),
+				Body:       io.NopCloser(bytes.NewReader(make([]byte, 0))),
+			},
+		},
+	}
+	wh, err := CreateBackend("http://backend/wh/"+uuid.NewString(), nil)
+	assert.NoError(err)
+	wh.client = &fc
+
+	err = wh.Send(BackendMessage{SessionId: uuid.NewString(), Event: ClientConnected}, &testSessionHandle{})
+	assert.NoError(err)
+	assert.Empty(fc.Requests[0].Header.Get(SignatureHeader), "request should not be signed")
+	assert.ErrorIs(VerifyRequest(fc.Requests[0], []string{"secret"}, time.Minute), ErrMissingSignature)
+}
+
+func TestVerifySignature(t *testing.T) {
+	assert := assert.New(t)
+	secret := []byte("secret")
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := ComputeSignature(secret, now, []byte("a"), []byte("b"))
+
+	assert.NoError(VerifySignature([][]byte{secret}, time.Minute, signature, now, []byte("a"), []byte("b")))
+	assert.NoError(VerifySignature([][]byte{secret}, time.Minute, "invalid,"+signature, now, []byte("a"), []byte("b")))
+	assert.ErrorIs(VerifySignature([][]byte{secret}, time.Minute, signature, now, []byte("a"), []byte("c")), ErrInvalidSignature)
+	assert.ErrorIs(VerifySignature([][]byte{secret}, time.Minute, signature, "not-a-number", []byte("a"), []byte("b")), ErrInvalidSignature)
+	assert.ErrorIs(VerifySignature([][]byte{secret}, time.Minute, "", now), ErrMissingSignature)
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	oldSignature := ComputeSignature(secret, old, []byte("a"), []byte("b"))
+	assert.ErrorIs(VerifySignature([][]byte{secret}, time.Minute, oldSignature, old, []byte("a"), []byte("b")), ErrSignatureExpired)
+}
+
+func TestReplySignatureParts(t *testing.T) {
+	header := http.Header{
+		CommandHeader: {"subscribe"},
+		TopicsHeader:  {" news "},
+	}
+
+	parts := ReplySignatureParts("post", "/reply/a", header, []byte("body"))
+	assert.Equal(t, [][]byte{
+		[]byte("POST"),
+		[]byte("/reply/a"),
+		[]byte("ws-command:subscribe\nws-close-code:\nws-close-reason:\nws-topics:news\nws-message-type:\n"),
+		[]byte("body"),
+	}, parts)
+}
+
+func TestComputeSignatureUnambiguous(t *testing.T) {
+	secret := []byte("secret")
+
+	// session ID "a.b" with event "c" must not sign like session ID "a" with event "b.c"
+	assert.NotEqual(t,
+		ComputeSignature(secret, "1", []byte("a.b"), []byte("c")),
+		ComputeSignature(secret, "1", []byte("a"), []byte("b.c")))
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("1:13:a.b1:c"))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), ComputeSignature(secret, "1", []byte("a.b"), []byte("c")))
+}
@@ -1,24 +1,75 @@
 package flags
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/frontend"
 	"github.com/ws2wh/ws2wh/http-middleware/jwt"
 	"github.com/ws2wh/ws2wh/metrics"
 	"github.com/ws2wh/ws2wh/server"
+	"github.com/ws2wh/ws2wh/session"
 )
 
 func LoadConfig() *server.Config {
 
 	backendUrl := flag.String("b", getEnvOrDefault("BACKEND_URL", ""), "Required - Webhook backend URL (must accept POST)")
+	backendSigningSecrets := flag.String("backend-signing-secrets", getEnvOrDefault("BACKEND_SIGNING_SECRETS", ""), "(Optional) Comma separated secrets used to sign webhook requests with HMAC-SHA256")
+	backendTimeout := flag.Duration("backend-timeout", getEnvDurationOrDefault("BACKEND_TIMEOUT", 30*time.Second), "Maximum duration of a single webhook request")
+	backendDialTimeout := flag.Duration("backend-dial-timeout", getEnvDurationOrDefault("BACKEND_DIAL_TIMEOUT", 10*time.Second), "Maximum duration of establishing a backend connection")
+	backendTlsHandshakeTimeout := flag.Duration("backend-tls-handshake-timeout", getEnvDurationOrDefault("BACKEND_TLS_HANDSHAKE_TIMEOUT", 10*time.Second), "Maximum duration of the backend TLS handshake")
+	backendIdleConnTimeout := flag.Duration("backend-idle-conn-timeout", getEnvDurationOrDefault("BACKEND_IDLE_CONN_TIMEOUT", 90*time.Second), "Time an idle backend connection is kept open")
+	backendMaxIdleConns := flag.Int("backend-max-idle-conns", getEnvIntOrDefault("BACKEND_MAX_IDLE_CONNS", 100), "Maximum number of idle backend connections")
+	backendMaxIdleConnsPerHost := flag.Int("backend-max-idle-conns-per-host", getEnvIntOrDefault("BACKEND_MAX_IDLE_CONNS_PER_HOST", 100), "Maximum number of idle connections per backend host")
+	backendMaxConnsPerHost := flag.Int("backend-max-conns-per-host", getEnvIntOrDefault("BACKEND_MAX_CONNS_PER_HOST", 0), "Maximum number of connections per backend host (0 for unlimited)")
+	backendHttp2Enabled := flag.String("backend-http2-enabled", getEnvOrDefault("BACKEND_HTTP2_ENABLED", "true"), "Allow HTTP/2 for webhook requests")
+	backendProxyUrl := flag.String("backend-proxy-url", getEnvOrDefault("BACKEND_PROXY_URL", ""), "(Optional) Proxy URL for webhook requests (default: HTTP_PROXY and HTTPS_PROXY)")
+	backendCaBundlePath := flag.String("backend-ca-bundle-path", getEnvOrDefault("BACKEND_CA_BUNDLE_PATH", ""), "(Optional) PEM CA bundle trusted for webhook requests in addition to system CAs")
+	backendClientCertPath := flag.String("backend-client-cert-path", getEnvOrDefault("BACKEND_CLIENT_CERT_PATH", ""), "(Optional) Client certificate path for backend mTLS (PEM format). Required if client key path set.")
+	backendClientKeyPath := flag.String("backend-client-key-path", getEnvOrDefault("BACKEND_CLIENT_KEY_PATH", ""), "(Optional) Client key path for backend mTLS (PEM format). Required if client certificate path set.")
+	backendRetryMaxAttempts := flag.Int("backend-retry-max-attempts", getEnvIntOrDefault("BACKEND_RETRY_MAX_ATTEMPTS", 1), "Maximum number of webhook delivery attempts (1 disables retries)")
+	backendRetryInitialBackoff := flag.Duration("backend-retry-initial-backoff", getEnvDurationOrDefault("BACKEND_RETRY_INITIAL_BACKOFF", 200*time.Millisecond), "Delay before the first webhook delivery retry, doubled on every following retry")
+	backendRetryMaxBackoff := flag.Duration("backend-retry-max-backoff", getEnvDurationOrDefault("BACKEND_RETRY_MAX_BACKOFF", 10*time.Second), "Maximum delay between webhook delivery attempts")
+	backendRetryJitter := flag.Float64("backend-retry-jitter", getEnvFloatOrDefault("BACKEND_RETRY_JITTER", 0), "Randomized fraction of the webhook retry delay (0-1, 0 disables)")
+	backendRetryStatuses := flag.String("backend-retry-statuses", getEnvOrDefault("BACKEND_RETRY_STATUSES", "408,425,429,500,502,503,504"), "Comma separated webhook response status codes that are retried")
+	deadLetterType := flag.String("dead-letter-type", getEnvOrDefault("DEAD_LETTER_TYPE", "none"), "Dead letter sink type for undeliverable messages (none, file, http)")
+	deadLetterPath := flag.String("dead-letter-path", getEnvOrDefault("DEAD_LETTER_PATH", ""), "Dead letter file path or URL depending on dead letter type")
+	deliveryMode := flag.String("delivery-mode", getEnvOrDefault("DELIVERY_MODE", "ordered"), "Backend delivery mode (ordered, concurrent)")
+	deliveryQueueSize := flag.Int("delivery-queue-size", getEnvIntOrDefault("DELIVERY_QUEUE_SIZE", 64), "Maximum number of messages waiting for backend delivery per session")
+	deliveryMaxInFlight := flag.Int("delivery-max-in-flight", getEnvIntOrDefault("DELIVERY_MAX_IN_FLIGHT", 4), "Maximum number of concurrent backend requests per session (concurrent mode)")
+	deliveryBackpressure := flag.String("delivery-backpressure", getEnvOrDefault("DELIVERY_BACKPRESSURE", "block"), "Policy applied when the delivery queue is full (block, drop-oldest, disconnect)")
 	replyPathPrefix := flag.String("r", getEnvOrDefault("REPLY_PATH_PREFIX", "/reply"), "Backend reply path prefix")
+	broadcastPath := flag.String("broadcast-path", getEnvOrDefault("BROADCAST_PATH", "/broadcast"), "Path for delivering messages to all sessions (empty disables)")
+	multicastPath := flag.String("multicast-path", getEnvOrDefault("MULTICAST_PATH", "/multicast"), "Path for delivering messages to a list of sessions (empty disables)")
+	topicsPathPrefix := flag.String("topics-path-prefix", getEnvOrDefault("TOPICS_PATH_PREFIX", "/topics"), "Path prefix for publishing messages to topic subscribers (empty disables)")
 	websocketListener := flag.String("l", fmt.Sprintf(":%s", getEnvOrDefault("WS_PORT", "3000")), "Websocket frontend listener address")
 	websocketPath := flag.String("p", getEnvOrDefault("WS_PATH", "/"), "Websocket upgrade path")
+	pingInterval := flag.Duration("ws-ping-interval", getEnvDurationOrDefault("WS_PING_INTERVAL", 30*time.Second), "Interval of ping frames sent to WebSocket clients (0 disables)")
+	pongWait := flag.Duration("ws-pong-wait", getEnvDurationOrDefault("WS_PONG_WAIT", 60*time.Second), "Maximum time without a pong or message before a client is disconnected (0 disables)")
+	idleTimeout := flag.Duration("ws-idle-timeout", getEnvDurationOrDefault("WS_IDLE_TIMEOUT", 0), "Maximum time without data messages before a client is disconnected (0 disables)")
+	writeWait := flag.Duration("ws-write-wait", getEnvDurationOrDefault("WS_WRITE_WAIT", 10*time.Second), "Maximum time to write a single message to a WebSocket client")
+	outboundQueueSize := flag.Int("ws-outbound-queue-size", getEnvIntOrDefault("WS_OUTBOUND_QUEUE_SIZE", 64), "Maximum number of messages waiting to be written per WebSocket client")
+	maxMessageSize := flag.Int("ws-max-message-size", getEnvIntOrDefault("WS_MAX_MESSAGE_SIZE", 0), "Maximum size in bytes of a message received from a WebSocket client (0 disables the limit)")
+	maxOutboundMessageSize := flag.Int("ws-max-outbound-message-size", getEnvIntOrDefault("WS_MAX_OUTBOUND_MESSAGE_SIZE", 0), "Maximum size in bytes of a message sent to a WebSocket client (0 disables the limit)")
+	slowConsumerPolicy := flag.String("ws-slow-consumer-policy", getEnvOrDefault("WS_SLOW_CONSUMER_POLICY", "block"), "Policy applied when the outbound queue of a client is full (block, drop-oldest, disconnect)")
+	originPolicy := flag.String("ws-origin-policy", getEnvOrDefault("WS_ORIGIN_POLICY", ""), "Origin check of WebSocket upgrades (same-origin, allowlist, allow-all; default: allowlist if allowed origins are set, otherwise same-origin)")
+	allowedOrigins := flag.String("ws-allowed-origins", getEnvOrDefault("WS_ALLOWED_ORIGINS", ""), "Comma separated origins accepted by the allowlist policy (e.g. https://app.example.com,https://*.example.com)")
+	subprotocols := flag.String("ws-subprotocols", getEnvOrDefault("WS_SUBPROTOCOLS", ""), "Comma separated WebSocket subprotocols supported by the server in order of preference (e.g. graphql-transport-ws,mqtt)")
+	subprotocolBackend := flag.String("ws-subprotocol-backend", getEnvOrDefault("WS_SUBPROTOCOL_BACKEND", "false"), "Let the backend select the subprotocol in its client-connected response (true/false)")
+	backendAdmission := flag.String("ws-backend-admission", getEnvOrDefault("WS_BACKEND_ADMISSION", "false"), "Send the client-connected event before the upgrade and only upgrade connections accepted by the backend with a 2xx response (true/false)")
+	forwardHeaders := flag.String("forward-headers", getEnvOrDefault("FORWARD_HEADERS", ""), "Comma separated upgrade request headers forwarded to the backend (e.g. User-Agent,Origin,Cookie)")
+	trustedProxies := flag.String("trusted-proxies", getEnvOrDefault("TRUSTED_PROXIES", ""), "Comma separated IP addresses or CIDR ranges of proxies trusted to set X-Forwarded-For")
+	contextConnected := flag.String("context-connected", getEnvOrDefault("CONTEXT_CONNECTED", "all"), "Session context fields sent with client-connected events (all, none or a list of claims, query, headers, connected-at)")
+	contextMessage := flag.String("context-message", getEnvOrDefault("CONTEXT_MESSAGE", "all"), "Session context fields sent with message-received events (all, none or a list of claims, query, headers, connected-at)")
+	contextDisconnected := flag.String("context-disconnected", getEnvOrDefault("CONTEXT_DISCONNECTED", "all"), "Session context fields sent with client-disconnected events (all, none or a list of claims, query, headers, connected-at)")
 	logLevel := flag.String("v", getEnvOrDefault("LOG_LEVEL", "INFO"), "Log level (DEBUG,	INFO, WARN, ERROR; default: INFO)")
 	hostname := flag.String("h", getEnvOrDefault("REPLY_HOSTNAME", getEnvOrDefault("HOSTNAME", "localhost")), "Hostname to use in reply channel")
 	enableMetrics := flag.String("metrics-enabled", getEnvOrDefault("METRICS_ENABLED", "false"), "Enable Prometheus metrics")
@@ -33,6 +84,31 @@ func LoadConfig() *server.Config {
 	jwtSecretType := flag.String("jwt-secret-type", getEnvOrDefault("JWT_SECRET_TYPE", "jwks-url"), "JWT secret type (jwks-file, jwks-url, openid)")
 	jwtSecretPath := flag.String("jwt-secret-path", getEnvOrDefault("JWT_SECRET_PATH", ""), "Path to JWT secret (file path or URL depending on secret type)")
 	jwtQueryParam := flag.String("jwt-query-param", getEnvOrDefault("JWT_QUERY_PARAM", "token"), "Query parameter name for JWT token")
+	jwtLeeway := flag.Duration("jwt-leeway", getEnvDurationOrDefault("JWT_LEEWAY", 0), "Clock skew tolerated when validating the exp, nbf and iat JWT claims")
+	jwtMaxTokenAge := flag.Duration("jwt-max-token-age", getEnvDurationOrDefault("JWT_MAX_TOKEN_AGE", 0), "Maximum JWT age based on the iat claim (0 disables)")
+	jwtRequireSub := flag.String("jwt-require-sub", getEnvOrDefault("JWT_REQUIRE_SUB", "false"), "Reject JWTs without a sub claim")
+	jwtClaimRules := flag.String("jwt-claim-rules", getEnvOrDefault("JWT_CLAIM_RULES", ""), "JSON list of claim rules a JWT must satisfy, e.g. [{\"claim\":\"scope\",\"operator\":\"contains\",\"value\":\"ws:connect\"}]")
+	jwtExpiryClose := flag.String("jwt-expiry-close", getEnvOrDefault("JWT_EXPIRY_CLOSE", "false"), "Close sessions when their JWT expires")
+	jwtExpiryWarning := flag.Duration("jwt-expiry-warning", getEnvDurationOrDefault("JWT_EXPIRY_WARNING", 0), "Time before the JWT expiry the warning message is sent (0 disables)")
+	jwtExpiryWarningMessage := flag.String("jwt-expiry-warning-message", getEnvOrDefault("JWT_EXPIRY_WARNING_MESSAGE", session.DefaultExpiryWarningMessage), "Text message sent before the JWT expiry ({exp} is replaced with the expiry in seconds since the epoch)")
+	jwtExpiryCloseCode := flag.Int("jwt-expiry-close-code", getEnvIntOrDefault("JWT_EXPIRY_CLOSE_CODE", session.DefaultExpiryCloseCode), "Close code of sessions whose JWT expired")
+	jwtExpiryCloseReason := flag.String("jwt-expiry-close-reason", getEnvOrDefault("JWT_EXPIRY_CLOSE_REASON", session.DefaultExpiryCloseReason), "Close reason of sessions whose JWT expired")
+	jwtRefreshType := flag.String("jwt-refresh-type", getEnvOrDefault("JWT_REFRESH_TYPE", session.DefaultRefreshType), "Type of the client messages carrying a new JWT")
+	jwtJwksCacheTtl := flag.Duration("jwt-jwks-cache-ttl", getEnvDurationOrDefault("JWT_JWKS_CACHE_TTL", 5*time.Minute), "Time JWT keys fetched from a URL are cached unless the response has a Cache-Control max-age (0 fetches keys once at startup)")
+	jwtJwksMinRefreshInterval := flag.Duration("jwt-jwks-min-refresh-interval", getEnvDurationOrDefault("JWT_JWKS_MIN_REFRESH_INTERVAL", 30*time.Second), "Minimum time between fetches of cached JWT keys, e.g. on unknown key IDs")
+	jwtTokenSources := flag.String("jwt-token-sources", getEnvOrDefault("JWT_TOKEN_SOURCES", "query"), "Comma-separated list of JWT token sources tried in order (query, header, cookie, subprotocol)")
+	jwtCookieName := flag.String("jwt-cookie-name", getEnvOrDefault("JWT_COOKIE_NAME", ""), "Cookie name for JWT token (cookie token source)")
+	jwtSubprotocolMarker := flag.String("jwt-subprotocol-marker", getEnvOrDefault("JWT_SUBPROTOCOL_MARKER", "bearer"), "Subprotocol preceding the JWT token in Sec-WebSocket-Protocol (subprotocol token source)")
+	sessionIdClaim := flag.String("session-id-claim", getEnvOrDefault("SESSION_ID_CLAIM", ""), "JWT claim used as session ID if the token contains it (e.g. sub)")
+	sessionIdDuplicatePolicy := flag.String("session-id-duplicate-policy", getEnvOrDefault("SESSION_ID_DUPLICATE_POLICY", "reject"), "Policy for session IDs already in use (reject, replace)")
+	replyAuthMode := flag.String("reply-auth-mode", getEnvOrDefault("REPLY_AUTH_MODE", "none"), "Reply channel authentication mode (none, bearer, hmac, jwt)")
+	replyAuthToken := flag.String("reply-auth-token", getEnvOrDefault("REPLY_AUTH_TOKEN", ""), "Reply channel shared bearer token (bearer mode)")
+	replyAuthHmacSecret := flag.String("reply-auth-hmac-secret", getEnvOrDefault("REPLY_AUTH_HMAC_SECRET", ""), "Reply channel HMAC signature secret (hmac mode)")
+	replyAuthHmacTolerance := flag.Duration("reply-auth-hmac-tolerance", getEnvDurationOrDefault("REPLY_AUTH_HMAC_TOLERANCE", 5*time.Minute), "Reply channel maximum signature timestamp difference (hmac mode)")
+	replyAuthJwtIssuer := flag.String("reply-auth-jwt-issuer", getEnvOrDefault("REPLY_AUTH_JWT_ISSUER", ""), "Reply channel JWT issuer (jwt mode)")
+	replyAuthJwtAudience := flag.String("reply-auth-jwt-audience", getEnvOrDefault("REPLY_AUTH_JWT_AUDIENCE", ""), "Reply channel JWT audience (jwt mode)")
+	replyAuthJwtSecretType := flag.String("reply-auth-jwt-secret-type", getEnvOrDefault("REPLY_AUTH_JWT_SECRET_TYPE", "jwks-url"), "Reply channel JWT secret type (jwks-file, jwks-url, openid)")
+	replyAuthJwtSecretPath := flag.String("reply-auth-jwt-secret-path", getEnvOrDefault("REPLY_AUTH_JWT_SECRET_PATH", ""), "Path to reply channel JWT secret (file path or URL depending on secret type)")
 
 	flag.Parse()
 
@@ -56,6 +132,85 @@ func LoadConfig() *server.Config {
 		os.Exit(1)
 	}
 
+	if (*backendClientCertPath == "") != (*backendClientKeyPath == "") {
+		slog.Error("Backend client certificate and key paths must be set together")
+		os.Exit(1)
+	}
+
+	if *jwtEnable == "true" && *jwtSecretPath == "" {
+		slog.Error("JWT enabled but JWT secret path not set")
+		os.Exit(1)
+	}
+
+	claimRules := make([]jwt.ClaimRule, 0)
+	if *jwtClaimRules != "" {
+		if err := json.Unmarshal([]byte(*jwtClaimRules), &claimRules); err != nil {
+			slog.Error("Invalid JWT claim rules", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	tokenSources := make([]jwt.TokenSource, 0)
+	for _, source := range splitList(*jwtTokenSources) {
+		tokenSources = append(tokenSources, jwt.TokenSource(source))
+	}
+
+	retryStatuses := make([]int, 0)
+	for _, status := range splitList(*backendRetryStatuses) {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 599 {
+			slog.Error("Invalid backend retry status code", "status", status)
+			os.Exit(1)
+		}
+		retryStatuses = append(retryStatuses, code)
+	}
+
+	replyAuthConfig := &server.ReplyAuthConfig{
+		Mode:          server.ReplyAuthMode(*replyAuthMode),
+		BearerToken:   *replyAuthToken,
+		HmacSecret:    *replyAuthHmacSecret,
+		HmacTolerance: *replyAuthHmacTolerance,
+	}
+
+	switch replyAuthConfig.Mode {
+	case server.ReplyAuthNone:
+	case server.ReplyAuthBearer:
+		if *replyAuthToken == "" {
+			slog.Error("Reply auth mode bearer requires reply auth token")
+			os.Exit(1)
+		}
+	case server.ReplyAuthHmac:
+		if *replyAuthHmacSecret == "" {
+			slog.Error("Reply auth mode hmac requires reply auth HMAC secret")
+			os.Exit(1)
+		}
+	case server.ReplyAuthJwt:
+		if *replyAuthJwtSecretPath == "" {
+			slog.Error("Reply auth mode jwt requires reply auth JWT secret path")
+			os.Exit(1)
+		}
+		replyAuthConfig.JwtConfig = &jwt.JwtConfig{
+			Enabled:      true,
+			SecretSource: createSecretProvider(*replyAuthJwtSecretType, *replyAuthJwtSecretPath, *jwtJwksCacheTtl, *jwtJwksMinRefreshInterval),
+			Issuer:       *replyAuthJwtIssuer,
+			Audience:     *replyAuthJwtAudience,
+		}
+	default:
+		slog.Error("Unknown reply auth mode", "mode", *replyAuthMode)
+		os.Exit(1)
+	}
+
+	var tokenExpiryConfig *session.TokenExpiryConfig
+	if *jwtExpiryClose == "true" {
+		tokenExpiryConfig = &session.TokenExpiryConfig{
+			WarningBefore:  *jwtExpiryWarning,
+			WarningMessage: *jwtExpiryWarningMessage,
+			CloseCode:      *jwtExpiryCloseCode,
+			CloseReason:    *jwtExpiryCloseReason,
+			RefreshType:    *jwtRefreshType,
+		}
+	}
+
 	var replyScheme string
 	if *tlsCertPath != "" && *tlsKeyPath != "" {
 		replyScheme = "https"
@@ -65,6 +220,37 @@ func LoadConfig() *server.Config {
 
 	return &server.Config{
 		BackendUrl: *backendUrl,
+		BackendConfig: &backend.WebhookConfig{
+			SigningSecrets: splitList(*backendSigningSecrets),
+			DeadLetter:     createDeadLetterSink(*deadLetterType, *deadLetterPath, *backendTimeout),
+			Client: &backend.ClientConfig{
+				Timeout:             *backendTimeout,
+				DialTimeout:         *backendDialTimeout,
+				TLSHandshakeTimeout: *backendTlsHandshakeTimeout,
+				IdleConnTimeout:     *backendIdleConnTimeout,
+				MaxIdleConns:        *backendMaxIdleConns,
+				MaxIdleConnsPerHost: *backendMaxIdleConnsPerHost,
+				MaxConnsPerHost:     *backendMaxConnsPerHost,
+				DisableHTTP2:        *backendHttp2Enabled != "true",
+				ProxyUrl:            *backendProxyUrl,
+				CaBundlePath:        *backendCaBundlePath,
+				ClientCertPath:      *backendClientCertPath,
+				ClientKeyPath:       *backendClientKeyPath,
+			},
+			Retry: &backend.RetryConfig{
+				MaxAttempts:       *backendRetryMaxAttempts,
+				InitialBackoff:    *backendRetryInitialBackoff,
+				MaxBackoff:        *backendRetryMaxBackoff,
+				Jitter:            *backendRetryJitter,
+				RetryableStatuses: retryStatuses,
+			},
+		},
+		DeliveryConfig: &session.DeliveryConfig{
+			Mode:         session.DeliveryMode(*deliveryMode),
+			QueueSize:    *deliveryQueueSize,
+			MaxInFlight:  *deliveryMaxInFlight,
+			Backpressure: session.BackpressurePolicy(*deliveryBackpressure),
+		},
 		ReplyChannelConfig: &server.ReplyChannelConfig{
 			PathPrefix: *replyPathPrefix,
 			Hostname:   *hostname,
@@ -78,9 +264,42 @@ func LoadConfig() *server.Config {
 				}
 				return "3000" // fallback
 			}(),
+			BroadcastPath:    *broadcastPath,
+			MulticastPath:    *multicastPath,
+			TopicsPathPrefix: *topicsPathPrefix,
+			AuthConfig:       replyAuthConfig,
 		},
 		WebSocketListener: *websocketListener,
 		WebSocketPath:     *websocketPath,
+		FrontendConfig: &frontend.Config{
+			PingInterval:           *pingInterval,
+			PongWait:               *pongWait,
+			IdleTimeout:            *idleTimeout,
+			WriteWait:              *writeWait,
+			OutboundQueueSize:      *outboundQueueSize,
+			SlowConsumerPolicy:     frontend.SlowConsumerPolicy(*slowConsumerPolicy),
+			MaxMessageSize:         int64(*maxMessageSize),
+			MaxOutboundMessageSize: int64(*maxOutboundMessageSize),
+			OriginPolicy:           frontend.OriginPolicy(*originPolicy),
+			AllowedOrigins:         splitList(*allowedOrigins),
+			Subprotocols:           splitList(*subprotocols),
+			BackendSubprotocol:     *subprotocolBackend == "true",
+			BackendAdmission:       *backendAdmission == "true",
+		},
+		ContextConfig: &session.ContextConfig{
+			Connected:    parseContextFields(*contextConnected),
+			Message:      parseContextFields(*contextMessage),
+			Disconnected: parseContextFields(*contextDisconnected),
+		},
+		ForwardConfig: &server.ForwardConfig{
+			Headers:        splitList(*forwardHeaders),
+			TrustedProxies: splitList(*trustedProxies),
+		},
+		SessionIdConfig: &server.SessionIdConfig{
+			JwtClaim:        *sessionIdClaim,
+			DuplicatePolicy: server.DuplicatePolicy(*sessionIdDuplicatePolicy),
+		},
+		TokenExpiryConfig: tokenExpiryConfig,
 		LogLevel:          parse(*logLevel),
 		Hostname:          *hostname,
 
@@ -96,11 +315,18 @@ func LoadConfig() *server.Config {
 			TlsKeyPath:  *tlsKeyPath,
 		},
 		JwtConfig: &jwt.JwtConfig{
-			Enabled:      *jwtEnable == "true",
-			QueryParam:   *jwtQueryParam,
-			SecretSource: createSecretProvider(*jwtSecretType, *jwtSecretPath),
-			Issuer:       *jwtIssuer,
-			Audience:     *jwtAudience,
+			Enabled:           *jwtEnable == "true",
+			QueryParam:        *jwtQueryParam,
+			TokenSources:      tokenSources,
+			CookieName:        *jwtCookieName,
+			SubprotocolMarker: *jwtSubprotocolMarker,
+			SecretSource:      createSecretProvider(*jwtSecretType, *jwtSecretPath, *jwtJwksCacheTtl, *jwtJwksMinRefreshInterval),
+			Issuer:            *jwtIssuer,
+			Audience:          *jwtAudience,
+			Leeway:            *jwtLeeway,
+			MaxTokenAge:       *jwtMaxTokenAge,
+			RequireSubject:    *jwtRequireSub == "true",
+			ClaimRules:        claimRules,
 		},
 	}
 }
@@ -112,6 +338,78 @@ func getEnvOrDefault(key, fallback string) string {
 	return fallback
 }
 
+// parseContextFields reads a session context field list
+// "all" selects every field (nil), "none" selects no field
+func parseContextFields(value string) []session.ContextField {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "all":
+		return nil
+	case "none":
+		return []session.ContextField{}
+	}
+
+	fields := make([]session.ContextField, 0)
+	for _, field := range splitList(value) {
+		fields = append(fields, session.ContextField(field))
+	}
+	return fields
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnvDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Error("Invalid duration", "key", key, "value", value, "error", err)
+		os.Exit(1)
+	}
+
+	return d
+}
+
+func getEnvIntOrDefault(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Error("Invalid integer", "key", key, "value", value, "error", err)
+		os.Exit(1)
+	}
+
+	return i
+}
+
+func getEnvFloatOrDefault(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Error("Invalid number", "key", key, "value", value, "error", err)
+		os.Exit(1)
+	}
+
+	return f
+}
+
 func parse(logLevel string) slog.Level {
 	switch strings.ToUpper(logLevel) {
 	case "DEBUG":
@@ -128,18 +426,21 @@ func parse(logLevel string) slog.Level {
 	return slog.LevelInfo
 }
 
-func createSecretProvider(secretType, secretPath string) jwt.KeyProvider {
+// createSecretProvider creates the key provider of the secret type
+// Keys fetched from a URL are cached and refreshed unless cacheTTL is zero
+func createSecretProvider(secretType, secretPath string, cacheTTL, minRefreshInterval time.Duration) jwt.KeyProvider {
+	var provider jwt.KeyProvider
 	switch secretType {
 	case "jwks-file":
 		return &jwt.JWKSFileProvider{
 			FilePath: secretPath,
 		}
 	case "jwks-url":
-		return &jwt.JWKSURLProvider{
+		provider = &jwt.JWKSURLProvider{
 			URL: secretPath,
 		}
 	case "openid":
-		return &jwt.OpenIDConfigProvider{
+		provider = &jwt.OpenIDConfigProvider{
 			Issuer: secretPath,
 		}
 	default:
@@ -147,4 +448,41 @@ func createSecretProvider(secretType, secretPath string) jwt.KeyProvider {
 		os.Exit(1)
 		return nil
 	}
+
+	if cacheTTL <= 0 {
+		return provider
+	}
+
+	return &jwt.CachingKeyProvider{
+		Provider:           provider,
+		TTL:                cacheTTL,
+		MinRefreshInterval: minRefreshInterval,
+	}
+}
+
+// createDeadLetterSink creates the dead letter sink of the sink type
+// HTTP requests share the backend timeout
+func createDeadLetterSink(sinkType, path string, timeout time.Duration) backend.DeadLetterSink {
+	if sinkType != "none" && path == "" {
+		slog.Error("Dead letter sink enabled but dead letter path not set", "type", sinkType)
+		os.Exit(1)
+	}
+
+	switch sinkType {
+	case "none":
+		return nil
+	case "file":
+		return &backend.FileDeadLetterSink{
+			FilePath: path,
+		}
+	case "http":
+		return &backend.HttpDeadLetterSink{
+			URL:     path,
+			Timeout: timeout,
+		}
+	default:
+		slog.Error("Unknown dead letter type", "type", sinkType)
+		os.Exit(1)
+		return nil
+	}
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,10 +20,29 @@ func main() {
 	config := flags.LoadConfig()
 	logger.InitLogger(config)
 
+	if flag.Arg(0) == "replay" {
+		if flag.NArg() != 2 {
+			slog.Error("Usage: ws2wh [flags] replay <dead letter file>")
+			os.Exit(1)
+		}
+
+		if err := replay(config, flag.Arg(1)); err != nil {
+			slog.Error("Dead letter replay failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	metrics.StartMetricsServer(ctx, config.MetricsConfig)
-	server.CreateServerWithConfig(config).Start(ctx)
+	srv, err := server.CreateServerWithConfig(config)
+	if err != nil {
+		slog.Error("Failed to create server", "error", err)
+		cancel()
+		os.Exit(1)
+	}
+	srv.Start(ctx)
 
 	sigs := make(chan os.Signal, 1)
 
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+// replay delivers the messages stored in a dead letter file to the configured backend
+// Messages failing again are written to the configured dead letter sink
+// Returns an error if the file cannot be read or any message failed to be delivered
+func replay(config *server.Config, path string) error {
+	if sink, ok := config.BackendConfig.DeadLetter.(*backend.FileDeadLetterSink); ok && samePath(sink.FilePath, path) {
+		return fmt.Errorf("cannot replay the dead letter file %s while it is the configured dead letter sink", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := backend.CreateBackend(config.BackendUrl, config.BackendConfig)
+	if err != nil {
+		return err
+	}
+
+	replayed, failed := 0, 0
+	err = backend.ReadDeadLetters(f, func(letter backend.DeadLetter) error {
+		msg, err := letter.Message()
+		if err != nil {
+			return fmt.Errorf("invalid dead letter of session %s: %w", letter.SessionId, err)
+		}
+
+		err = b.Send(msg, &replaySession{sessionId: msg.SessionId})
+		if err != nil {
+			failed++
+			return nil
+		}
+
+		replayed++
+		return nil
+	})
+
+	slog.Info("Dead letter replay finished", "file", path, "replayed", replayed, "failed", failed)
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d messages failed to be replayed", failed)
+	}
+
+	return nil
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// replaySession is the session handle of replayed messages
+// The original sessions no longer exist, so backend responses are discarded
+type replaySession struct {
+	sessionId string
+}
+
+func (s *replaySession) Send(message []byte, messageType backend.MessageType) error {
+	slog.Warn("Discarding backend response to replayed message", "sessionId", s.sessionId)
+	return nil
+}
+
+func (s *replaySession) Close(closeCode int, closeReason *string) error {
+	return nil
+}
+
+func (s *replaySession) Subscribe(topics []string) error {
+	return nil
+}
+
+func (s *replaySession) Unsubscribe(topics []string) error {
+	return nil
+}
+
+func (s *replaySession) SelectSubprotocol(protocol string) error {
+	return nil
+}
+
+func (s *replaySession) SetUpgradeHeader(header http.Header) {
+}
+
+func (s *replaySession) AssignSessionId(id string) error {
+	return nil
+}
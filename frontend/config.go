@@ -0,0 +1,144 @@
+package frontend
+
+import (
+	"fmt"
+	"time"
+)
+
+// SlowConsumerPolicy selects what happens when the outbound queue of a connection is full
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerBlock waits until there is room in the outbound queue
+	SlowConsumerBlock SlowConsumerPolicy = "block"
+	// SlowConsumerDropOldest discards the oldest queued message to make room for the new one
+	SlowConsumerDropOldest SlowConsumerPolicy = "drop-oldest"
+	// SlowConsumerDisconnect closes the client connection
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+const (
+	defaultWriteWait         = 10 * time.Second
+	defaultOutboundQueueSize = 64
+)
+
+// Config holds the WebSocket connection parameters
+type Config struct {
+	// PingInterval is the interval of ping frames sent to the client (0 disables pings)
+	PingInterval time.Duration
+	// PongWait is the maximum time without a pong or message from the client before
+	// the connection is considered dead (0 disables the deadline; must be greater than PingInterval)
+	PongWait time.Duration
+	// IdleTimeout closes connections without data messages in either direction for the given time
+	// Ping and pong frames do not count as traffic (0 disables the timeout)
+	IdleTimeout time.Duration
+	// WriteWait is the maximum time to write a single frame to the client (default: 10s)
+	WriteWait time.Duration
+	// OutboundQueueSize is the maximum number of messages waiting to be written per connection (default: 64)
+	OutboundQueueSize int
+	// SlowConsumerPolicy selects what happens when the outbound queue is full
+	// (block, drop-oldest, disconnect; default: block)
+	SlowConsumerPolicy SlowConsumerPolicy
+	// OriginPolicy selects how the Origin header of upgrade requests is checked
+	// (same-origin, allowlist, allow-all; default: allowlist if AllowedOrigins is set, otherwise same-origin)
+	OriginPolicy OriginPolicy
+	// AllowedOrigins lists the origins accepted by the allowlist policy
+	// e.g. https://app.example.com or https://*.example.com for any subdomain
+	AllowedOrigins []string
+	// Subprotocols lists the supported WebSocket subprotocols in order of preference
+	// The first one requested by the client is negotiated (empty disables negotiation)
+	Subprotocols []string
+	// BackendSubprotocol lets the backend select one of the subprotocols requested by the client
+	// in its response to the client-connected event (the event is sent before the upgrade)
+	BackendSubprotocol bool
+	// MaxMessageSize is the maximum size in bytes of a message received from the client (0 disables the limit)
+	// Larger messages close the connection with 1009 (Message Too Big)
+	MaxMessageSize int64
+	// MaxOutboundMessageSize is the maximum size in bytes of a message sent to the client (0 disables the limit)
+	// Larger messages are rejected with ErrMessageTooLarge
+	MaxOutboundMessageSize int64
+	// BackendAdmission sends the client-connected event before the upgrade and only upgrades connections
+	// the backend answers with a 2xx status code; other responses are returned to the client
+	BackendAdmission bool
+}
+
+// Validate checks the connection configuration
+// Returns an error if a value is negative, a policy is unknown, an allowed origin is invalid,
+// subprotocols are configured together with backend selection or the pong wait is not greater than the ping interval
+func (c *Config) Validate() error {
+	if c.PingInterval < 0 || c.PongWait < 0 || c.IdleTimeout < 0 || c.WriteWait < 0 {
+		return fmt.Errorf("connection timeouts must not be negative")
+	}
+
+	if c.OutboundQueueSize < 0 {
+		return fmt.Errorf("outbound queue size must not be negative")
+	}
+
+	if c.MaxMessageSize < 0 || c.MaxOutboundMessageSize < 0 {
+		return fmt.Errorf("maximum message sizes must not be negative")
+	}
+
+	switch c.SlowConsumerPolicy {
+	case "", SlowConsumerBlock, SlowConsumerDropOldest, SlowConsumerDisconnect:
+	default:
+		return fmt.Errorf("unknown slow consumer policy: %s", c.SlowConsumerPolicy)
+	}
+
+	switch c.OriginPolicy {
+	case "", OriginSameOrigin, OriginAllowAll:
+	case OriginAllowlist:
+		if len(c.AllowedOrigins) == 0 {
+			return fmt.Errorf("allowed origins are required by the allowlist origin policy")
+		}
+	default:
+		return fmt.Errorf("unknown origin policy: %s", c.OriginPolicy)
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if err := validateAllowedOrigin(origin); err != nil {
+			return err
+		}
+	}
+
+	if c.BackendSubprotocol && len(c.Subprotocols) > 0 {
+		return fmt.Errorf("subprotocols cannot be configured when the backend selects the subprotocol")
+	}
+
+	for _, protocol := range c.Subprotocols {
+		if protocol == "" {
+			return fmt.Errorf("subprotocols must not be empty")
+		}
+	}
+
+	if c.PingInterval > 0 && c.PongWait > 0 && c.PongWait <= c.PingInterval {
+		return fmt.Errorf("pong wait (%s) must be greater than ping interval (%s)", c.PongWait, c.PingInterval)
+	}
+
+	return nil
+}
+
+// withDefaults returns a copy of the configuration with unset values replaced by defaults
+func (c *Config) withDefaults() Config {
+	config := Config{}
+	if c != nil {
+		config = *c
+	}
+
+	if config.WriteWait <= 0 {
+		config.WriteWait = defaultWriteWait
+	}
+	if config.OutboundQueueSize <= 0 {
+		config.OutboundQueueSize = defaultOutboundQueueSize
+	}
+	if config.SlowConsumerPolicy == "" {
+		config.SlowConsumerPolicy = SlowConsumerBlock
+	}
+	if config.OriginPolicy == "" {
+		config.OriginPolicy = OriginSameOrigin
+		if len(config.AllowedOrigins) > 0 {
+			config.OriginPolicy = OriginAllowlist
+		}
+	}
+
+	return config
+}
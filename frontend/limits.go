@@ -0,0 +1,66 @@
+package frontend
+
+import (
+	"errors"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/backend"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// ErrMessageTooLarge is returned when sending a message larger than the maximum outbound message size
+var ErrMessageTooLarge = errors.New("message too large")
+
+// ErrInvalidUtf8 is returned when sending a text message that is not valid UTF-8
+var ErrInvalidUtf8 = errors.New("text message is not valid UTF-8")
+
+const (
+	// MessageTooBigCloseCode is the close code sent to clients exceeding the maximum message size (Message Too Big)
+	MessageTooBigCloseCode = websocket.CloseMessageTooBig
+	// MessageTooBigReason is the close reason of clients exceeding the maximum message size
+	MessageTooBigReason = "Message too big"
+	// InvalidUtf8CloseCode is the close code sent to clients sending text messages that are not valid UTF-8
+	// (Invalid Frame Payload Data)
+	InvalidUtf8CloseCode = websocket.CloseInvalidFramePayloadData
+	// InvalidUtf8Reason is the close reason of clients sending text messages that are not valid UTF-8
+	InvalidUtf8Reason = "Invalid UTF-8"
+)
+
+// checkOutbound validates a message before it is queued for the client
+// Returns ErrMessageTooLarge or ErrInvalidUtf8 if the message is rejected
+func (h *WebsocketHandler) checkOutbound(data []byte, messageType backend.MessageType) error {
+	if h.config.MaxOutboundMessageSize > 0 && int64(len(data)) > h.config.MaxOutboundMessageSize {
+		countRejected(m.ReasonValueOutboundTooLarge)
+		h.logger.Warn("Rejected message larger than the maximum outbound message size", "size", len(data))
+		return ErrMessageTooLarge
+	}
+
+	if messageType == backend.TextMessage && !utf8.Valid(data) {
+		countRejected(m.ReasonValueOutboundInvalidUtf8)
+		h.logger.Warn("Rejected text message with invalid UTF-8")
+		return ErrInvalidUtf8
+	}
+
+	return nil
+}
+
+// checkInbound validates a message received from the client
+// Text messages that are not valid UTF-8 close the connection
+// Returns false if the message must not be forwarded to the backend
+func (h *WebsocketHandler) checkInbound(data []byte, messageType int) bool {
+	if messageType == websocket.TextMessage && !utf8.Valid(data) {
+		countRejected(m.ReasonValueInboundInvalidUtf8)
+		h.closeWithInfo(InvalidUtf8CloseCode, InvalidUtf8Reason, backend.CloseOriginServer)
+		return false
+	}
+
+	return true
+}
+
+func countRejected(reason string) {
+	m.MessageRejectedCounter.With(prometheus.Labels{
+		m.ReasonLabel: reason,
+	}).Inc()
+}
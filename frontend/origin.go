@@ -0,0 +1,93 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// OriginPolicy selects how the Origin header of upgrade requests is checked
+type OriginPolicy string
+
+const (
+	// OriginSameOrigin accepts browser clients served from the same host as the WebSocket endpoint
+	OriginSameOrigin OriginPolicy = "same-origin"
+	// OriginAllowlist accepts browser clients from the configured allowed origins
+	OriginAllowlist OriginPolicy = "allowlist"
+	// OriginAllowAll accepts any origin (development only)
+	OriginAllowAll OriginPolicy = "allow-all"
+)
+
+// validateAllowedOrigin checks an allowed origin pattern
+// Patterns are scheme://host[:port], the host may start with a *. wildcard label
+func validateAllowedOrigin(pattern string) error {
+	u, err := url.Parse(strings.Replace(pattern, "://*.", "://wildcard.", 1))
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.User != nil {
+		return fmt.Errorf("invalid allowed origin %q: expected scheme://host[:port]", pattern)
+	}
+
+	if strings.Contains(u.Host, "*") {
+		return fmt.Errorf("invalid allowed origin %q: wildcards are only supported as the first host label", pattern)
+	}
+
+	return nil
+}
+
+// matchOrigin reports whether the origin matches the allowed origin pattern
+// A *. wildcard matches one or more subdomain labels, but not the domain itself
+func matchOrigin(pattern string, origin string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+	if pattern == origin {
+		return true
+	}
+
+	scheme, domain, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+
+	originScheme, originHost, ok := strings.Cut(origin, "://")
+	if !ok || originScheme != scheme {
+		return false
+	}
+
+	subdomain, ok := strings.CutSuffix(originHost, "."+domain)
+	return ok && subdomain != "" && !strings.HasPrefix(subdomain, ".")
+}
+
+// checkOrigin is the upgrader origin check of the handler
+// Requests without an Origin header are not sent by browsers and are always accepted
+func (h *WebsocketHandler) checkOrigin(r *http.Request) bool {
+	allowed := h.originAllowed(r)
+	if !allowed {
+		m.OriginRejectedCounter.Inc()
+		h.logger.Warn("Rejected WebSocket upgrade from disallowed origin", "origin", r.Header.Get("Origin"), "policy", h.config.OriginPolicy)
+	}
+
+	return allowed
+}
+
+// originAllowed reports whether the origin policy accepts the Origin header of the request
+func (h *WebsocketHandler) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || h.config.OriginPolicy == OriginAllowAll {
+		return true
+	}
+
+	normalized := strings.ToLower(origin)
+	switch h.config.OriginPolicy {
+	case OriginAllowlist:
+		for _, pattern := range h.config.AllowedOrigins {
+			if matchOrigin(pattern, normalized) {
+				return true
+			}
+		}
+		return false
+	default:
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+}
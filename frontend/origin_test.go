@@ -0,0 +1,59 @@
+package frontend
+
+import (
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	assert.True(t, matchOrigin("https://app.example.com", "https://app.example.com"))
+	assert.True(t, matchOrigin("HTTPS://App.Example.com/", "https://app.example.com"))
+	assert.False(t, matchOrigin("https://app.example.com", "http://app.example.com"))
+	assert.False(t, matchOrigin("https://app.example.com", "https://app.example.com:8443"))
+
+	assert.True(t, matchOrigin("https://*.example.com", "https://app.example.com"))
+	assert.True(t, matchOrigin("https://*.example.com", "https://a.b.example.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://example.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://evilexample.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://example.com.evil.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "http://app.example.com"))
+}
+
+func TestValidateAllowedOrigin(t *testing.T) {
+	assert.NoError(t, validateAllowedOrigin("https://app.example.com"))
+	assert.NoError(t, validateAllowedOrigin("https://*.example.com:8443"))
+	assert.Error(t, validateAllowedOrigin("app.example.com"))
+	assert.Error(t, validateAllowedOrigin("https://app.example.com/path"))
+	assert.Error(t, validateAllowedOrigin("https://app.*.example.com"))
+}
+
+func TestCheckOrigin(t *testing.T) {
+	check := func(config *Config, host string, origin string) bool {
+		r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return NewWsHandler(*slog.Default(), "test", config).checkOrigin(r)
+	}
+
+	assert.True(t, check(nil, "ws.example.com", ""), "requests without origin should be accepted")
+	assert.True(t, check(nil, "ws.example.com", "https://ws.example.com"))
+	assert.False(t, check(nil, "ws.example.com", "https://evil.com"), "same-origin should be the default")
+
+	allowlist := &Config{AllowedOrigins: []string{"https://*.example.com"}}
+	assert.True(t, check(allowlist, "ws.example.net", "https://app.example.com"))
+	assert.False(t, check(allowlist, "ws.example.net", "https://ws.example.net"), "allowlist should not accept same origin")
+
+	assert.True(t, check(&Config{OriginPolicy: OriginAllowAll}, "ws.example.com", "https://evil.com"))
+}
+
+func TestConfig_ValidateOrigins(t *testing.T) {
+	assert.NoError(t, (&Config{OriginPolicy: OriginAllowAll}).Validate())
+	assert.NoError(t, (&Config{AllowedOrigins: []string{"https://*.example.com"}}).Validate())
+	assert.Error(t, (&Config{OriginPolicy: OriginAllowlist}).Validate())
+	assert.Error(t, (&Config{OriginPolicy: "unknown"}).Validate())
+	assert.Error(t, (&Config{AllowedOrigins: []string{"example.com"}}).Validate())
+}
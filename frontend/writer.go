@@ -0,0 +1,217 @@
+package frontend
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/backend"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+	"github.com/ws2wh/ws2wh/session"
+)
+
+// ErrConnectionClosed is returned when sending to a closed connection
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrOutboundQueueFull is returned when a message is rejected by the slow consumer policy
+var ErrOutboundQueueFull = errors.New("outbound queue full")
+
+// SlowConsumerCloseCode is the close code sent to clients disconnected by SlowConsumerDisconnect (Policy Violation)
+const SlowConsumerCloseCode = websocket.ClosePolicyViolation
+
+// SlowConsumerReason is the close reason of clients disconnected by SlowConsumerDisconnect
+const SlowConsumerReason = "Slow consumer"
+
+// outboundFrame is a data frame waiting for the writer goroutine
+type outboundFrame struct {
+	messageType backend.MessageType
+	payload     []byte
+}
+
+// Send queues a message for the writer goroutine using the given frame type
+// If the outbound queue is full, the slow consumer policy decides whether Send waits,
+// drops the oldest queued message or disconnects the client
+// Returns an error if the connection is closed, the client was disconnected or the message is rejected
+// because it exceeds the maximum outbound message size or is a text message with invalid UTF-8
+func (h *WebsocketHandler) Send(data []byte, messageType backend.MessageType) error {
+	if h.closed.Load() {
+		return ErrConnectionClosed
+	}
+
+	if err := h.checkOutbound(data, messageType); err != nil {
+		return err
+	}
+
+	frame := outboundFrame{messageType: messageType, payload: data}
+	select {
+	case h.outbound <- frame:
+		m.OutboundQueueGauge.Inc()
+		return nil
+	default:
+	}
+
+	m.SlowConsumerCounter.With(prometheus.Labels{
+		m.PolicyLabel: string(h.config.SlowConsumerPolicy),
+	}).Inc()
+
+	switch h.config.SlowConsumerPolicy {
+	case SlowConsumerDropOldest:
+		for {
+			select {
+			case <-h.outbound:
+				m.OutboundQueueGauge.Dec()
+				h.countFailure()
+				h.logger.Warn("Outbound queue full, dropping oldest message")
+			default:
+			}
+
+			select {
+			case h.outbound <- frame:
+				m.OutboundQueueGauge.Inc()
+				return nil
+			case <-h.stopped:
+				return ErrConnectionClosed
+			default:
+			}
+		}
+	case SlowConsumerDisconnect:
+		h.logger.Warn("Outbound queue full, disconnecting slow client")
+		h.countFailure()
+		h.closeWithInfo(SlowConsumerCloseCode, SlowConsumerReason, backend.CloseOriginServer)
+		return ErrOutboundQueueFull
+	default:
+		select {
+		case h.outbound <- frame:
+			m.OutboundQueueGauge.Inc()
+			return nil
+		case <-h.stopped:
+			return ErrConnectionClosed
+		}
+	}
+}
+
+// write is the single writer goroutine of the connection
+// It writes queued data frames and pings until the connection is closed or a write fails
+func (h *WebsocketHandler) write() {
+	defer close(h.stopped)
+	defer h.discardOutbound()
+	defer func() {
+		err := h.conn.Close()
+		if err != nil {
+			h.logger.Debug("Error while closing connection", "error", err)
+		}
+	}()
+
+	var ping <-chan time.Time
+	if h.config.PingInterval > 0 {
+		ticker := time.NewTicker(h.config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case frame := <-h.outbound:
+			m.OutboundQueueGauge.Dec()
+			if err := h.writeFrame(frame); err != nil {
+				h.writeFailed(err)
+				return
+			}
+		case <-ping:
+			err := h.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(h.config.WriteWait))
+			if err != nil {
+				h.writeFailed(err)
+				return
+			}
+		case <-h.closing:
+			h.writeClose()
+			return
+		case <-h.done:
+			select {
+			case <-h.closing:
+				h.writeClose()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// writeFrame writes a single data frame within the write wait
+func (h *WebsocketHandler) writeFrame(frame outboundFrame) error {
+	err := h.conn.SetWriteDeadline(time.Now().Add(h.config.WriteWait))
+	if err == nil {
+		err = h.conn.WriteMessage(int(frame.messageType), frame.payload)
+	}
+
+	if err != nil {
+		h.countFailure()
+		return err
+	}
+
+	h.touch()
+	m.MessageSuccessCounter.With(prometheus.Labels{
+		m.OriginLabel: m.OriginValueBackend,
+	}).Inc()
+	return nil
+}
+
+// writeClose flushes the queued frames and writes the close frame
+func (h *WebsocketHandler) writeClose() {
+	for {
+		select {
+		case frame := <-h.outbound:
+			m.OutboundQueueGauge.Dec()
+			if err := h.writeFrame(frame); err != nil {
+				h.logger.Debug("Error while flushing message before close", "error", err)
+				return
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	err := h.conn.WriteControl(websocket.CloseMessage, h.closeMessage, time.Now().Add(h.config.WriteWait))
+	if err != nil {
+		h.logger.Debug("Error while sending close message", "error", err)
+	}
+}
+
+// writeFailed ends the connection after a failed write
+// Write timeouts are reported to the backend as timeouts, other errors as a lost client connection
+func (h *WebsocketHandler) writeFailed(err error) {
+	h.logger.Error("Error while sending message to client", "error", err)
+
+	info := session.CloseInfo{Code: websocket.CloseAbnormalClosure, Origin: backend.CloseOriginClient}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		info = session.CloseInfo{Code: TimeoutCloseCode, Reason: WriteTimeoutReason, Origin: backend.CloseOriginTimeout}
+	}
+
+	if h.closed.CompareAndSwap(false, true) {
+		h.closeInfo.Store(&info)
+		h.signal(session.ConnectionClosedSignal)
+	}
+}
+
+// discardOutbound drops the frames left in the queue when the writer ends
+func (h *WebsocketHandler) discardOutbound() {
+	for {
+		select {
+		case <-h.outbound:
+			m.OutboundQueueGauge.Dec()
+			h.countFailure()
+		default:
+			return
+		}
+	}
+}
+
+func (h *WebsocketHandler) countFailure() {
+	m.MessageFailureCounter.With(prometheus.Labels{
+		m.OriginLabel: m.OriginValueBackend,
+	}).Inc()
+}
@@ -0,0 +1,86 @@
+package frontend
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/session"
+)
+
+func TestSend_SlowConsumerBlock(t *testing.T) {
+	h := NewWsHandler(*slog.Default(), "test", &Config{OutboundQueueSize: 1})
+
+	assert.NoError(t, h.Send([]byte("first"), backend.TextMessage))
+	close(h.stopped)
+	assert.ErrorIs(t, h.Send([]byte("second"), backend.TextMessage), ErrConnectionClosed)
+}
+
+func TestSend_SlowConsumerDropOldest(t *testing.T) {
+	h := NewWsHandler(*slog.Default(), "test", &Config{
+		OutboundQueueSize:  2,
+		SlowConsumerPolicy: SlowConsumerDropOldest,
+	})
+
+	for _, payload := range []string{"first", "second", "third"} {
+		assert.NoError(t, h.Send([]byte(payload), backend.TextMessage))
+	}
+
+	assert.Equal(t, "second", string((<-h.outbound).payload))
+	assert.Equal(t, "third", string((<-h.outbound).payload))
+}
+
+func TestSend_SlowConsumerDisconnect(t *testing.T) {
+	h := NewWsHandler(*slog.Default(), "test", &Config{
+		OutboundQueueSize:  1,
+		SlowConsumerPolicy: SlowConsumerDisconnect,
+	})
+
+	assert.NoError(t, h.Send([]byte("first"), backend.TextMessage))
+	assert.ErrorIs(t, h.Send([]byte("second"), backend.TextMessage), ErrOutboundQueueFull)
+	assert.Equal(t, &session.CloseInfo{
+		Code:   SlowConsumerCloseCode,
+		Reason: SlowConsumerReason,
+		Origin: backend.CloseOriginServer,
+	}, h.CloseInfo())
+	assert.Equal(t, session.ConnectionClosedSignal, <-h.Signal())
+
+	select {
+	case <-h.closing:
+	default:
+		assert.Fail(t, "connection should be closing")
+	}
+
+	assert.ErrorIs(t, h.Send([]byte("third"), backend.TextMessage), ErrConnectionClosed)
+}
+
+func TestSend_RejectsInvalidMessages(t *testing.T) {
+	h := NewWsHandler(*slog.Default(), "test", &Config{MaxOutboundMessageSize: 4})
+
+	assert.ErrorIs(t, h.Send([]byte("too large"), backend.BinaryMessage), ErrMessageTooLarge)
+	assert.ErrorIs(t, h.Send([]byte{0xff, 0xfe}, backend.TextMessage), ErrInvalidUtf8)
+	assert.NoError(t, h.Send([]byte{0xff, 0xfe}, backend.BinaryMessage), "binary messages are not validated")
+	assert.NoError(t, h.Send([]byte("żó"), backend.TextMessage))
+}
+
+func TestConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&Config{}).Validate())
+	assert.NoError(t, (&Config{SlowConsumerPolicy: SlowConsumerDisconnect}).Validate())
+	assert.Error(t, (&Config{SlowConsumerPolicy: "unknown"}).Validate())
+	assert.Error(t, (&Config{OutboundQueueSize: -1}).Validate())
+	assert.Error(t, (&Config{WriteWait: -1}).Validate())
+	assert.Error(t, (&Config{MaxMessageSize: -1}).Validate())
+	assert.NoError(t, (&Config{Subprotocols: []string{"mqtt"}}).Validate())
+	assert.Error(t, (&Config{Subprotocols: []string{""}}).Validate())
+	assert.Error(t, (&Config{Subprotocols: []string{"mqtt"}, BackendSubprotocol: true}).Validate())
+}
+
+func TestConfig_WithDefaults(t *testing.T) {
+	var config *Config
+	defaults := config.withDefaults()
+
+	assert.Equal(t, defaultWriteWait, defaults.WriteWait)
+	assert.Equal(t, defaultOutboundQueueSize, defaults.OutboundQueueSize)
+	assert.Equal(t, SlowConsumerBlock, defaults.SlowConsumerPolicy)
+}
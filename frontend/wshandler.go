@@ -4,64 +4,83 @@
 package frontend
 
 import (
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/backend"
 	m "github.com/ws2wh/ws2wh/metrics/directory"
 	"github.com/ws2wh/ws2wh/session"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+const (
+	// TimeoutCloseCode is the close code sent when a connection times out (Going Away)
+	TimeoutCloseCode = websocket.CloseGoingAway
+	// PongTimeoutReason is the close reason of connections without a pong within the pong wait
+	PongTimeoutReason = "Pong timeout"
+	// IdleTimeoutReason is the close reason of connections without data messages within the idle timeout
+	IdleTimeoutReason = "Idle timeout"
+	// WriteTimeoutReason is the close reason of connections where a write exceeded the write wait
+	WriteTimeoutReason = "Write timeout"
+)
 
 // NewWsHandler creates a new WebsocketHandler with initialized channels
 // for receiving messages and handling connection termination
-func NewWsHandler(logger slog.Logger, id string) *WebsocketHandler {
+// config holds the connection parameters (nil for defaults)
+func NewWsHandler(logger slog.Logger, id string, config *Config) *WebsocketHandler {
 	h := WebsocketHandler{
-		receiverChannel: make(chan []byte, 64),
+		receiverChannel: make(chan session.Message, 64),
 		signalChannel:   make(chan session.ConnectionSignal, 64),
+		done:            make(chan struct{}),
+		closing:         make(chan struct{}),
+		stopped:         make(chan struct{}),
 		logger:          logger,
 		sessionId:       id,
 	}
 
+	h.config = config.withDefaults()
+	h.outbound = make(chan outboundFrame, h.config.OutboundQueueSize)
+
 	return &h
 }
 
 // WebsocketHandler manages a WebSocket connection and provides an interface
 // for sending/receiving messages and handling connection lifecycle
+// All frames are written by a single writer goroutine fed by a bounded outbound queue
 type WebsocketHandler struct {
-	receiverChannel chan []byte
+	receiverChannel chan session.Message
 	signalChannel   chan session.ConnectionSignal
-	conn            *websocket.Conn
-	logger          slog.Logger
-	sessionId       string
-	closed          atomic.Bool
-}
-
-// Send writes a message to the WebSocket connection
-func (h *WebsocketHandler) Send(data []byte) error {
-	err := h.conn.WriteMessage(websocket.TextMessage, data)
-
-	if err != nil {
-		h.logger.Error("Error while sending message to client", "error", err)
-		m.MessageFailureCounter.With(prometheus.Labels{
-			m.OriginLabel: m.OriginValueBackend,
-		}).Inc()
-	} else {
-		m.MessageSuccessCounter.With(prometheus.Labels{
-			m.OriginLabel: m.OriginValueBackend,
-		}).Inc()
-	}
-
-	return err
+	// signalLock guards signalChannel against sends after it was closed
+	signalLock   sync.Mutex
+	signalClosed bool
+	// outbound queues data frames for the writer goroutine
+	outbound chan outboundFrame
+	// done is closed when the read loop ends
+	done chan struct{}
+	// closing is closed when the connection should be closed with closeMessage
+	closing      chan struct{}
+	closeMessage []byte
+	// stopped is closed when the writer goroutine ends
+	stopped   chan struct{}
+	conn      *websocket.Conn
+	config    Config
+	logger    slog.Logger
+	sessionId string
+	closed    atomic.Bool
+	closeInfo atomic.Pointer[session.CloseInfo]
+	// lastActivity is the unix time in nanoseconds of the last data message in either direction
+	lastActivity atomic.Int64
 }
 
 // Receiver returns a channel for receiving incoming WebSocket messages
-func (h *WebsocketHandler) Receiver() <-chan []byte {
+func (h *WebsocketHandler) Receiver() <-chan session.Message {
 	return h.receiverChannel
 }
 
@@ -70,66 +89,218 @@ func (h *WebsocketHandler) Signal() <-chan session.ConnectionSignal {
 	return h.signalChannel
 }
 
-// Close gracefully terminates the WebSocket connection
-func (h *WebsocketHandler) Close(closeCode int, closeReason *string) error {
-	if h.closed.Load() {
-		return nil
+// CloseInfo returns the close code, reason and origin of the connection
+// Returns nil while the connection is open
+func (h *WebsocketHandler) CloseInfo() *session.CloseInfo {
+	return h.closeInfo.Load()
+}
+
+// Subprotocol returns the negotiated subprotocol
+// Returns an empty string before the upgrade or if no subprotocol was negotiated
+func (h *WebsocketHandler) Subprotocol() string {
+	if h.conn == nil {
+		return ""
 	}
 
-	h.closed.Store(true)
+	return h.conn.Subprotocol()
+}
 
-	defer func() {
-		err := h.conn.Close()
-		if err != nil {
-			h.logger.Error("Error while closing connection", "error", err)
+// selectSubprotocol returns the first configured subprotocol requested by the client
+// Returns an empty string if none was requested
+func (h *WebsocketHandler) selectSubprotocol(r *http.Request) string {
+	requested := websocket.Subprotocols(r)
+	for _, protocol := range h.config.Subprotocols {
+		if slices.Contains(requested, protocol) {
+			return protocol
 		}
-	}()
+	}
+
+	return ""
+}
 
-	h.signalChannel <- session.ConnectionClosedSignal
+// AssignSessionId updates the session ID of the handler and its logger
+// It must be called before Handle, once the backend assigned the session ID
+func (h *WebsocketHandler) AssignSessionId(id string) {
+	h.sessionId = id
+	h.logger = *slog.Default().With("sessionId", id)
+}
+
+// BackendSubprotocol reports whether the backend selects the subprotocol before the upgrade
+func (h *WebsocketHandler) BackendSubprotocol() bool {
+	return h.config.BackendSubprotocol
+}
 
+// BackendAdmission reports whether the backend admits connections before the upgrade
+func (h *WebsocketHandler) BackendAdmission() bool {
+	return h.config.BackendAdmission
+}
+
+// CanUpgrade reports whether the request is a WebSocket upgrade from an allowed origin
+// Requests that cannot be upgraded are rejected by Handle without reaching the backend
+func (h *WebsocketHandler) CanUpgrade(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r) && h.originAllowed(r)
+}
+
+// Close gracefully terminates the WebSocket connection on behalf of the backend
+// Messages queued before the call are written before the close frame
+func (h *WebsocketHandler) Close(closeCode int, closeReason *string) error {
 	var reason string
 	if closeReason != nil {
 		reason = *closeReason
 	}
 
-	closeMessage := websocket.FormatCloseMessage(closeCode, reason)
-	err := h.conn.WriteMessage(websocket.CloseMessage, closeMessage)
-	if err != nil {
-		return err
+	return h.CloseWithInfo(session.CloseInfo{
+		Code:   closeCode,
+		Reason: reason,
+		Origin: backend.CloseOriginBackend,
+	})
+}
+
+// CloseWithInfo gracefully terminates the WebSocket connection with the given close code and reason
+// The close info is reported to the backend in the client-disconnected event
+// Messages queued before the call are written before the close frame
+func (h *WebsocketHandler) CloseWithInfo(info session.CloseInfo) error {
+	if !h.closed.CompareAndSwap(false, true) {
+		return nil
 	}
 
+	h.closeInfo.Store(&info)
+	h.signal(session.ConnectionClosedSignal)
+
+	h.closeMessage = websocket.FormatCloseMessage(info.Code, info.Reason)
+	close(h.closing)
+
 	return nil
 }
 
+// closeWithInfo closes the connection for a reason decided by the server
+func (h *WebsocketHandler) closeWithInfo(code int, reason string, origin backend.CloseOrigin) {
+	h.logger.Info("Closing connection", "closeCode", code, "reason", reason, "origin", origin)
+	err := h.CloseWithInfo(session.CloseInfo{Code: code, Reason: reason, Origin: origin})
+	if err != nil {
+		h.logger.Debug("Error while closing connection", "error", err)
+	}
+}
+
+// signal notifies the session about the connection state
+// Signals sent after the connection handling ended are dropped
+func (h *WebsocketHandler) signal(signal session.ConnectionSignal) {
+	h.signalLock.Lock()
+	defer h.signalLock.Unlock()
+	if !h.signalClosed {
+		h.signalChannel <- signal
+	}
+}
+
+func (h *WebsocketHandler) closeSignal() {
+	h.signalLock.Lock()
+	defer h.signalLock.Unlock()
+	h.signalClosed = true
+	close(h.signalChannel)
+}
+
+// touch records data traffic for the idle timeout
+func (h *WebsocketHandler) touch() {
+	h.lastActivity.Store(time.Now().UnixNano())
+}
+
+// extendReadDeadline pushes the pong deadline after traffic from the client
+func (h *WebsocketHandler) extendReadDeadline() {
+	if h.config.PongWait > 0 {
+		err := h.conn.SetReadDeadline(time.Now().Add(h.config.PongWait))
+		if err != nil {
+			h.logger.Debug("Error while setting read deadline", "error", err)
+		}
+	}
+}
+
+// watchIdle closes the connection when there is no data traffic for the idle timeout
+func (h *WebsocketHandler) watchIdle() {
+	timer := time.NewTimer(h.config.IdleTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, h.lastActivity.Load()))
+			if idle >= h.config.IdleTimeout {
+				h.closeWithInfo(TimeoutCloseCode, IdleTimeoutReason, backend.CloseOriginTimeout)
+				return
+			}
+			timer.Reset(h.config.IdleTimeout - idle)
+		}
+	}
+}
+
 // Handle upgrades an HTTP connection to WebSocket and manages the connection lifecycle.
 // It reads messages from the connection and forwards them to the receiver channel.
 // The connection is terminated when a close message is received or on error.
+// A subprotocol selected by the backend must be set as Sec-WebSocket-Protocol in responseHeader.
+// A configured subprotocol requested by the client takes precedence over the one in responseHeader.
 func (h *WebsocketHandler) Handle(w http.ResponseWriter, r *http.Request, responseHeader http.Header) error {
-	defer close(h.signalChannel)
-	defer func() { h.signalChannel <- session.ConnectionClosedSignal }()
+	defer h.closeSignal()
+	defer h.signal(session.ConnectionClosedSignal)
+	// later Close calls must not signal the closed channel
+	defer h.closed.Store(true)
 	defer close(h.receiverChannel)
 
 	h.logger.Info("Upgrading HTTP to WS")
 
+	if protocol := h.selectSubprotocol(r); protocol != "" {
+		responseHeader.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	// the subprotocol is taken from responseHeader
+	upgrader := websocket.Upgrader{
+		CheckOrigin: h.checkOrigin,
+	}
 	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		h.logger.Error("Error while upgrading connection", "error", err)
+		h.discardOutbound()
+		close(h.stopped)
 		return err
 	}
 
 	m.ConnectCounter.Inc()
 	h.conn = conn
-	h.signalChannel <- session.ConnectionReadySignal
+	if h.config.MaxMessageSize > 0 {
+		conn.SetReadLimit(h.config.MaxMessageSize)
+	}
+	defer close(h.done)
+	go h.write()
+	h.touch()
+	h.extendReadDeadline()
+	conn.SetPongHandler(func(string) error {
+		h.extendReadDeadline()
+		return nil
+	})
+
+	if h.config.IdleTimeout > 0 {
+		go h.watchIdle()
+	}
+
+	h.signal(session.ConnectionReadySignal)
 
 	for {
-		_, msg, err := conn.ReadMessage()
+		messageType, msg, err := conn.ReadMessage()
 
 		if err != nil {
 			return h.handleReadMessageErr(err)
 		}
 
-		h.logger.Debug("Received message", "data", string(msg))
-		h.receiverChannel <- msg
+		h.touch()
+		h.extendReadDeadline()
+		if !h.checkInbound(msg, messageType) {
+			continue
+		}
+
+		h.logger.Debug("Received message", "data", string(msg), "messageType", messageType)
+		h.receiverChannel <- session.Message{
+			Type:    backend.MessageType(messageType),
+			Payload: msg,
+		}
 	}
 }
 
@@ -138,30 +309,78 @@ func (h *WebsocketHandler) handleReadMessageErr(err error) error {
 		return nil
 	}
 
-	defer func() { h.signalChannel <- session.ConnectionClosedSignal }()
+	defer h.signal(session.ConnectionClosedSignal)
 
-	if h.closed.Load() {
+	var netErr net.Error
+	if !h.closed.Load() && errors.As(err, &netErr) && netErr.Timeout() {
+		h.closeWithInfo(TimeoutCloseCode, PongTimeoutReason, backend.CloseOriginTimeout)
+	}
+
+	if errors.Is(err, websocket.ErrReadLimit) && h.closed.CompareAndSwap(false, true) {
+		// the close frame was already sent by the connection
+		countRejected(m.ReasonValueInboundTooLarge)
+		h.logger.Warn("Closing connection after message larger than the maximum message size", "limit", h.config.MaxMessageSize)
+		h.closeInfo.Store(&session.CloseInfo{Code: MessageTooBigCloseCode, Reason: MessageTooBigReason, Origin: backend.CloseOriginServer})
 		m.DisconnectCounter.With(prometheus.Labels{
-			m.OriginLabel: m.OriginValueBackend,
+			m.OriginLabel: m.OriginValueServer,
 		}).Inc()
-		h.logger.Info("Backend closed connection")
 		return nil
 	}
 
-	if websocket.IsCloseError(err, 1000, 1001, 1005) {
+	if !h.closed.CompareAndSwap(false, true) {
+		info := h.CloseInfo()
 		m.DisconnectCounter.With(prometheus.Labels{
-			m.OriginLabel: m.OriginValueClient,
+			m.OriginLabel: disconnectOrigin(info),
 		}).Inc()
-
-		h.logger.Info("Client closed connection")
+		if info != nil && info.Origin != backend.CloseOriginBackend {
+			h.logger.Info("Server closed connection", "reason", info.Reason, "origin", info.Origin)
+		} else {
+			h.logger.Info("Backend closed connection")
+		}
 		return nil
 	}
 
+	info := session.CloseInfo{Code: websocket.CloseAbnormalClosure, Origin: backend.CloseOriginClient}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		info.Code = closeErr.Code
+		info.Reason = closeErr.Text
+	}
+	h.closeInfo.Store(&info)
+
 	m.DisconnectCounter.With(prometheus.Labels{
 		m.OriginLabel: m.OriginValueClient,
 	}).Inc()
 
+	if websocket.IsCloseError(err, 1000, 1001, 1005) {
+		h.logger.Info("Client closed connection", "closeCode", info.Code, "reason", info.Reason)
+		return nil
+	}
+
 	h.logger.Error("Error while reading message", "error", err)
 
 	return err
 }
+
+// disconnectOrigin returns the disconnect metric origin matching the close origin of a closed connection
+// Connections closed without close info were closed by the backend
+func disconnectOrigin(info *session.CloseInfo) string {
+	if info == nil {
+		return m.OriginValueBackend
+	}
+
+	switch info.Origin {
+	case backend.CloseOriginClient:
+		return m.OriginValueClient
+	case backend.CloseOriginTimeout:
+		return m.OriginValueTimeout
+	case backend.CloseOriginServer:
+		return m.OriginValueServer
+	case backend.CloseOriginShutdown:
+		return m.OriginValueShutdown
+	case backend.CloseOriginTokenExpired:
+		return m.OriginValueTokenExpired
+	default:
+		return m.OriginValueBackend
+	}
+}
@@ -0,0 +1,105 @@
+package jwt
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// TokenError is returned by Verify for rejected tokens
+// Reason is the reason label of the ws2wh_jwt_rejected_total metric
+type TokenError struct {
+	Reason string
+	Err    error
+}
+
+func (e *TokenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TokenError) Unwrap() error {
+	return e.Err
+}
+
+func rejectToken(reason string, format string, args ...any) *TokenError {
+	return &TokenError{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// validateTimes checks the exp, nbf and iat claims and the token age
+// Claims are optional unless a maximum token age requires iat
+func (a *JwtAuthorizer) validateTimes(claims map[string]interface{}, now time.Time) error {
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(a.leeway)) {
+		return rejectToken(m.ReasonValueTokenExpired, "token expired at %s", exp.Format(time.RFC3339))
+	}
+
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(a.leeway).Before(nbf) {
+		return rejectToken(m.ReasonValueTokenNotYetValid, "token not valid before %s", nbf.Format(time.RFC3339))
+	}
+
+	iat, ok, err := numericDate(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(a.leeway).Before(iat) {
+		return rejectToken(m.ReasonValueTokenIssuedInFuture, "token issued in the future at %s", iat.Format(time.RFC3339))
+	}
+
+	if a.maxTokenAge > 0 {
+		if !ok {
+			return rejectToken(m.ReasonValueTokenTooOld, "missing iat required by the maximum token age")
+		}
+		if now.Sub(iat) > a.maxTokenAge+a.leeway {
+			return rejectToken(m.ReasonValueTokenTooOld, "token issued at %s exceeds the maximum age of %s", iat.Format(time.RFC3339), a.maxTokenAge)
+		}
+	}
+
+	return nil
+}
+
+// numericDate reads a NumericDate claim (seconds since the epoch)
+// Returns false if the claim is missing
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false, rejectToken(m.ReasonValueTokenClaims, "invalid %s claim: %v", name, value)
+	}
+
+	date, err := ParseNumericDate(seconds)
+	if err != nil {
+		return time.Time{}, false, rejectToken(m.ReasonValueTokenClaims, "invalid %s claim: %v", name, err)
+	}
+
+	return date, true, nil
+}
+
+// NumericDate bounds, the dates from year 1 to year 9999 (RFC 3339)
+const (
+	minNumericDate = -62135596800
+	maxNumericDate = 253402300799
+)
+
+// ParseNumericDate converts a JWT NumericDate (seconds since the epoch, possibly fractional) to a time
+// Returns an error for NaN, infinite values and dates outside the years 1 to 9999
+func ParseNumericDate(seconds float64) (time.Time, error) {
+	if math.IsNaN(seconds) || seconds < minNumericDate || seconds > maxNumericDate {
+		return time.Time{}, fmt.Errorf("numeric date out of range: %v", seconds)
+	}
+
+	sec, frac := math.Modf(seconds)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
@@ -1,9 +1,26 @@
 package jwt
 
+import "time"
+
 type JwtConfig struct {
-	Enabled      bool
-	QueryParam   string
-	SecretSource KeyProvider
-	Issuer       string
-	Audience     string
+	Enabled    bool
+	QueryParam string
+	// TokenSources lists where the token is looked up, tried in order (default: query)
+	TokenSources []TokenSource
+	// CookieName is the name of the cookie holding the token (cookie source)
+	CookieName string
+	// SubprotocolMarker is the subprotocol preceding the token in Sec-WebSocket-Protocol
+	// (subprotocol source; default: bearer)
+	SubprotocolMarker string
+	SecretSource      KeyProvider
+	Issuer            string
+	Audience          string
+	// Leeway is the clock skew tolerated when validating exp, nbf and iat (default: 0)
+	Leeway time.Duration
+	// MaxTokenAge rejects tokens issued (iat) longer ago; tokens without iat are rejected when set (0 disables)
+	MaxTokenAge time.Duration
+	// RequireSubject rejects tokens without a sub claim
+	RequireSubject bool
+	// ClaimRules allow upgrades only if the token claims satisfy all of them (optional)
+	ClaimRules []ClaimRule
 }
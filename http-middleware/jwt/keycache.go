@@ -0,0 +1,165 @@
+package jwt
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+const (
+	defaultCacheTTL           = 5 * time.Minute
+	defaultMinRefreshInterval = 30 * time.Second
+)
+
+// RefreshingKeyProvider is a KeyProvider whose keys change at runtime
+// The authorizer asks it for the keys of every token instead of fetching them once at startup
+type RefreshingKeyProvider interface {
+	KeyProvider
+	// Refresh refetches the keys, e.g. after a token signed with an unknown key ID
+	Refresh() (*jose.JSONWebKeySet, error)
+}
+
+// cacheableKeyProvider is a KeyProvider reporting how long its keys may be cached
+type cacheableKeyProvider interface {
+	// getKeysWithMaxAge returns the keys and the Cache-Control max-age of the response
+	// Returns false if the response had no caching directive
+	getKeysWithMaxAge() (*jose.JSONWebKeySet, time.Duration, bool, error)
+}
+
+// CachingKeyProvider caches the keys of another provider
+// Expired keys are served while they are refreshed in the background
+// Failed fetches keep the last fetched keys, so the keys only become unavailable if they were never fetched
+type CachingKeyProvider struct {
+	Provider KeyProvider
+	// TTL is how long keys are cached unless the JWKS response has a Cache-Control max-age (default: 5m)
+	TTL time.Duration
+	// MinRefreshInterval is the minimum time between fetches, limiting refreshes on unknown key IDs
+	// and retries after failed fetches (default: 30s)
+	MinRefreshInterval time.Duration
+
+	// fetchLock serializes fetches
+	fetchLock sync.Mutex
+	// lock guards the cached state
+	lock       sync.Mutex
+	keys       *jose.JSONWebKeySet
+	expires    time.Time
+	lastFetch  time.Time
+	lastErr    error
+	refreshing bool
+}
+
+// GetKeys returns the cached keys
+// Expired keys are returned while a background refresh fetches new ones
+// Keys are fetched before returning only if there are no cached keys
+func (p *CachingKeyProvider) GetKeys() (*jose.JSONWebKeySet, error) {
+	p.lock.Lock()
+	keys := p.keys
+	if keys != nil && !time.Now().Before(p.expires) && !p.refreshing {
+		p.refreshing = true
+		go p.refreshInBackground()
+	}
+	p.lock.Unlock()
+
+	if keys != nil {
+		return keys, nil
+	}
+
+	return p.Refresh()
+}
+
+// Refresh fetches the keys unless they were fetched within the minimum refresh interval
+// Returns the last fetched keys if the fetch fails or is rate limited
+func (p *CachingKeyProvider) Refresh() (*jose.JSONWebKeySet, error) {
+	p.fetchLock.Lock()
+	defer p.fetchLock.Unlock()
+
+	p.lock.Lock()
+	if !p.lastFetch.IsZero() && time.Since(p.lastFetch) < p.minRefreshInterval() {
+		keys, err := p.keys, p.lastErr
+		p.lock.Unlock()
+		if keys != nil {
+			return keys, nil
+		}
+		return nil, fmt.Errorf("keys unavailable until the next refresh: %w", err)
+	}
+	p.lock.Unlock()
+
+	return p.fetch()
+}
+
+func (p *CachingKeyProvider) refreshInBackground() {
+	p.fetchLock.Lock()
+	defer p.fetchLock.Unlock()
+
+	_, _ = p.fetch()
+
+	p.lock.Lock()
+	p.refreshing = false
+	p.lock.Unlock()
+}
+
+// fetch gets the keys from the provider and updates the cache
+// Must be called with fetchLock held
+func (p *CachingKeyProvider) fetch() (*jose.JSONWebKeySet, error) {
+	keys, ttl, err := p.getKeys()
+	now := time.Now()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.lastFetch = now
+	p.lastErr = err
+
+	if err != nil {
+		m.JwksRefreshFailureCounter.Inc()
+		// retry once the minimum refresh interval passed
+		p.expires = now.Add(p.minRefreshInterval())
+		if p.keys != nil {
+			slog.Warn("Failed to refresh JWT keys, using the last fetched keys", "error", err)
+			return p.keys, nil
+		}
+		slog.Error("Failed to fetch JWT keys", "error", err)
+		return nil, err
+	}
+
+	m.JwksRefreshCounter.Inc()
+	slog.Debug("Fetched JWT keys", "keys", len(keys.Keys), "ttl", ttl)
+	p.keys = keys
+	p.expires = now.Add(ttl)
+	return keys, nil
+}
+
+// getKeys gets the keys from the provider with the time they may be cached
+// A Cache-Control max-age overrides the TTL, but keys are cached for at least the minimum refresh interval
+func (p *CachingKeyProvider) getKeys() (*jose.JSONWebKeySet, time.Duration, error) {
+	ttl := p.TTL
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+
+	var keys *jose.JSONWebKeySet
+	var err error
+	if provider, ok := p.Provider.(cacheableKeyProvider); ok {
+		var maxAge time.Duration
+		var found bool
+		keys, maxAge, found, err = provider.getKeysWithMaxAge()
+		if found {
+			ttl = maxAge
+		}
+	} else {
+		keys, err = p.Provider.GetKeys()
+	}
+
+	return keys, max(ttl, p.minRefreshInterval()), err
+}
+
+func (p *CachingKeyProvider) minRefreshInterval() time.Duration {
+	if p.MinRefreshInterval <= 0 {
+		return defaultMinRefreshInterval
+	}
+
+	return p.MinRefreshInterval
+}
@@ -0,0 +1,192 @@
+package jwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJwksServer serves the current key set and counts the requests
+type testJwksServer struct {
+	*httptest.Server
+	lock         sync.Mutex
+	keys         *jose.JSONWebKeySet
+	cacheControl string
+	fail         bool
+	requests     atomic.Int32
+}
+
+func newTestJwksServer(t *testing.T, keys *jose.JSONWebKeySet) *testJwksServer {
+	s := &testJwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		_, _ = w.Write(mustMarshal(t, s.keys))
+	}))
+	return s
+}
+
+func (s *testJwksServer) set(keys *jose.JSONWebKeySet, fail bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys = keys
+	s.fail = fail
+}
+
+func symmetricKeySet(key []byte, kid string) *jose.JSONWebKeySet {
+	return &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:       key,
+				Use:       "sig",
+				Algorithm: string(jose.HS256),
+				KeyID:     kid,
+			},
+		},
+	}
+}
+
+func TestCachingKeyProvider(t *testing.T) {
+	t.Run("honours Cache-Control max-age", func(t *testing.T) {
+		server := newTestJwksServer(t, symmetricKeySet([]byte("first-secret-key-with-32-bytes!!"), "first"))
+		defer server.Close()
+		server.cacheControl = "public, max-age=3600"
+
+		provider := &CachingKeyProvider{
+			Provider:           &JWKSURLProvider{URL: server.URL},
+			TTL:                time.Millisecond,
+			MinRefreshInterval: time.Millisecond,
+		}
+
+		for range 3 {
+			keys, err := provider.GetKeys()
+			assert.NoError(t, err)
+			assert.Len(t, keys.Key("first"), 1)
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(t, int32(1), server.requests.Load(), "keys should be cached for the max-age")
+	})
+
+	t.Run("refreshes expired keys in the background", func(t *testing.T) {
+		server := newTestJwksServer(t, symmetricKeySet([]byte("first-secret-key-with-32-bytes!!"), "first"))
+		defer server.Close()
+
+		provider := &CachingKeyProvider{
+			Provider:           &JWKSURLProvider{URL: server.URL},
+			TTL:                10 * time.Millisecond,
+			MinRefreshInterval: time.Millisecond,
+		}
+
+		_, err := provider.GetKeys()
+		assert.NoError(t, err)
+		server.set(symmetricKeySet([]byte("second-secret-key-with-32-bytes!"), "second"), false)
+		time.Sleep(20 * time.Millisecond)
+
+		keys, err := provider.GetKeys()
+		assert.NoError(t, err)
+		assert.Len(t, keys.Key("first"), 1, "expired keys should be served during the refresh")
+
+		assert.Eventually(t, func() bool {
+			keys, err := provider.GetKeys()
+			return err == nil && len(keys.Key("second")) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("keeps the last fetched keys", func(t *testing.T) {
+		server := newTestJwksServer(t, symmetricKeySet([]byte("first-secret-key-with-32-bytes!!"), "first"))
+		defer server.Close()
+
+		provider := &CachingKeyProvider{
+			Provider:           &JWKSURLProvider{URL: server.URL},
+			MinRefreshInterval: time.Millisecond,
+		}
+
+		_, err := provider.GetKeys()
+		assert.NoError(t, err)
+		server.set(nil, true)
+		time.Sleep(5 * time.Millisecond)
+
+		keys, err := provider.Refresh()
+		assert.NoError(t, err)
+		assert.Len(t, keys.Key("first"), 1)
+		assert.Equal(t, int32(2), server.requests.Load())
+	})
+
+	t.Run("rate limits refreshes", func(t *testing.T) {
+		server := newTestJwksServer(t, symmetricKeySet([]byte("first-secret-key-with-32-bytes!!"), "first"))
+		defer server.Close()
+
+		provider := &CachingKeyProvider{
+			Provider:           &JWKSURLProvider{URL: server.URL},
+			MinRefreshInterval: time.Hour,
+		}
+
+		for range 3 {
+			_, err := provider.Refresh()
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), server.requests.Load())
+	})
+}
+
+func TestJwtAuthorizerKeyRotation(t *testing.T) {
+	firstKey := []byte("first-secret-key-with-32-bytes!!")
+	secondKey := []byte("second-secret-key-with-32-bytes!")
+	server := newTestJwksServer(t, nil)
+	defer server.Close()
+	server.set(nil, true)
+
+	authorizer, err := NewJwtAuthorizer(&JwtConfig{
+		QueryParam: "token",
+		SecretSource: &CachingKeyProvider{
+			Provider:           &JWKSURLProvider{URL: server.URL},
+			MinRefreshInterval: 10 * time.Millisecond,
+		},
+	})
+	assert.NoError(t, err, "authorizer should start while the keys are unavailable")
+
+	_, err = authorizer.Verify(createToken(t, firstKey, "first", jose.HS256))
+	assert.Error(t, err)
+
+	server.set(symmetricKeySet(firstKey, "first"), false)
+	time.Sleep(20 * time.Millisecond)
+	_, err = authorizer.Verify(createToken(t, firstKey, "first", jose.HS256))
+	assert.NoError(t, err, "keys should be fetched once the provider recovers")
+
+	server.set(symmetricKeySet(secondKey, "second"), false)
+	_, err = authorizer.Verify(createToken(t, secondKey, "second", jose.HS256))
+	assert.Error(t, err, "refreshes should be rate limited")
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = authorizer.Verify(createToken(t, secondKey, "second", jose.HS256))
+	assert.NoError(t, err, "unknown key IDs should refresh the keys")
+}
+
+func TestCacheMaxAge(t *testing.T) {
+	maxAge, ok := cacheMaxAge(http.Header{"Cache-Control": {"public, max-age=600"}})
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, maxAge)
+
+	maxAge, ok = cacheMaxAge(http.Header{"Cache-Control": {"no-store"}})
+	assert.True(t, ok)
+	assert.Zero(t, maxAge)
+
+	_, ok = cacheMaxAge(http.Header{})
+	assert.False(t, ok)
+	_, ok = cacheMaxAge(http.Header{"Cache-Control": {"max-age=invalid"}})
+	assert.False(t, ok)
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-jose/go-jose/v4"
@@ -58,25 +60,37 @@ type JWKSURLProvider struct {
 }
 
 func (p *JWKSURLProvider) GetKeys() (*jose.JSONWebKeySet, error) {
+	keys, _, _, err := p.getKeysWithMaxAge()
+	return keys, err
+}
+
+func (p *JWKSURLProvider) getKeysWithMaxAge() (*jose.JSONWebKeySet, time.Duration, bool, error) {
 	return p.fetchJWKS()
 }
 
-func (p *JWKSURLProvider) fetchJWKS() (*jose.JSONWebKeySet, error) {
+// fetchJWKS fetches the JWKS and the max-age of the response
+func (p *JWKSURLProvider) fetchJWKS() (*jose.JSONWebKeySet, time.Duration, bool, error) {
 	resp, err := httpClient.Get(p.URL)
 	if err != nil {
-		return nil, err
+		return nil, 0, false, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+		return nil, 0, false, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+		return nil, 0, false, fmt.Errorf("failed to read JWKS: %w", err)
 	}
 
-	return decodeJWKS(body)
+	keys, err := decodeJWKS(body)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	maxAge, ok := cacheMaxAge(resp.Header)
+	return keys, maxAge, ok, nil
 }
 
 // OpenIDConfigProvider provides secret using OpenID Connect discovery
@@ -86,18 +100,25 @@ type OpenIDConfigProvider struct {
 }
 
 func (p *OpenIDConfigProvider) GetKeys() (*jose.JSONWebKeySet, error) {
+	keys, _, _, err := p.getKeysWithMaxAge()
+	return keys, err
+}
+
+// getKeysWithMaxAge discovers the JWKS URL and fetches the JWKS
+// The max-age is the one of the JWKS response
+func (p *OpenIDConfigProvider) getKeysWithMaxAge() (*jose.JSONWebKeySet, time.Duration, bool, error) {
 	issuerURL, err := url.Parse(p.Issuer)
 	if err != nil {
-		return nil, fmt.Errorf("invalid issuer URL: %w", err)
+		return nil, 0, false, fmt.Errorf("invalid issuer URL: %w", err)
 	}
 	configURL := issuerURL.ResolveReference(&url.URL{Path: "/.well-known/openid-configuration"})
 	resp, err := httpClient.Get(configURL.String())
 	if err != nil {
-		return nil, err
+		return nil, 0, false, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch OpenID configuration: %s", resp.Status)
+		return nil, 0, false, fmt.Errorf("failed to fetch OpenID configuration: %s", resp.Status)
 	}
 
 	openIDConfig := struct {
@@ -105,10 +126,30 @@ func (p *OpenIDConfigProvider) GetKeys() (*jose.JSONWebKeySet, error) {
 	}{}
 	err = json.NewDecoder(resp.Body).Decode(&openIDConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode OpenID configuration: %w", err)
+		return nil, 0, false, fmt.Errorf("failed to decode OpenID configuration: %w", err)
 	}
 
 	p.URL = openIDConfig.JWKSUri
 
 	return p.fetchJWKS()
 }
+
+// cacheMaxAge returns the max-age of the Cache-Control header
+// no-cache and no-store are reported as a max-age of zero
+// Returns false if the header has no caching directive
+func cacheMaxAge(header http.Header) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -3,128 +3,264 @@ package jwt
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/go-jose/go-jose/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
 )
 
 // JwtClaimsKey is the context key for storing JWT claims
 type JwtClaimsKey struct{}
 
 type JwtAuthorizer struct {
-	queryParam string
-	issuer     string
-	audience   string
-	keys       *jose.JSONWebKeySet
+	queryParam        string
+	tokenSources      []TokenSource
+	cookieName        string
+	subprotocolMarker string
+	issuer            string
+	audience          string
+	leeway            time.Duration
+	maxTokenAge       time.Duration
+	requireSubject    bool
+	claimRules        []claimRule
+	keys              *jose.JSONWebKeySet
+	// refresher provides the keys of every token if the keys change at runtime (nil for static keys)
+	refresher RefreshingKeyProvider
 }
 
 func NewJwtAuthorizer(config *JwtConfig) (*JwtAuthorizer, error) {
-	keys, err := config.SecretSource.GetKeys()
+	if err := validateTokenSources(config); err != nil {
+		return nil, err
+	}
+	if config.Leeway < 0 {
+		return nil, fmt.Errorf("leeway must not be negative")
+	}
+	if config.MaxTokenAge < 0 {
+		return nil, fmt.Errorf("max token age must not be negative")
+	}
+
+	claimRules, err := compileRules(config.ClaimRules)
 	if err != nil {
-		slog.Error("Failed to get keys", "error", err)
 		return nil, err
 	}
+
+	refresher, _ := config.SecretSource.(RefreshingKeyProvider)
+	keys, err := config.SecretSource.GetKeys()
+	if err != nil {
+		if refresher == nil {
+			slog.Error("Failed to get keys", "error", err)
+			return nil, err
+		}
+		// tokens are rejected until the keys are fetched
+		slog.Warn("Failed to get keys, retrying on incoming tokens", "error", err)
+	}
+
+	tokenSources := config.TokenSources
+	if len(tokenSources) == 0 {
+		tokenSources = []TokenSource{TokenSourceQuery}
+	}
+	subprotocolMarker := config.SubprotocolMarker
+	if subprotocolMarker == "" {
+		subprotocolMarker = defaultSubprotocolMarker
+	}
+
 	return &JwtAuthorizer{
-		queryParam: config.QueryParam,
-		issuer:     config.Issuer,
-		audience:   config.Audience,
-		keys:       keys,
+		queryParam:        config.QueryParam,
+		tokenSources:      tokenSources,
+		cookieName:        config.CookieName,
+		subprotocolMarker: subprotocolMarker,
+		issuer:            config.Issuer,
+		audience:          config.Audience,
+		leeway:            config.Leeway,
+		maxTokenAge:       config.MaxTokenAge,
+		requireSubject:    config.RequireSubject,
+		claimRules:        claimRules,
+		keys:              keys,
+		refresher:         refresher,
 	}, nil
 }
 
+// Authorize verifies the token of the request, looked up in the configured token sources
+// Invalid tokens are rejected with 401 Unauthorized, valid tokens denied by the claim rules with 403 Forbidden
+// The claims are stored in the request context with JwtClaimsKey
+// Tokens read from Sec-WebSocket-Protocol are removed from the header and their marker is stored with SubprotocolMarkerKey
 func (a *JwtAuthorizer) Authorize(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.URL.Query().Get(a.queryParam)
+		token, marker := a.extractToken(r)
 		if token == "" {
+			countRejected(m.ReasonValueTokenMissing)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		signature, err := jose.ParseSigned(token, []jose.SignatureAlgorithm{
-			jose.EdDSA,
-			jose.HS256,
-			jose.HS384,
-			jose.HS512,
-			jose.RS256,
-			jose.RS384,
-			jose.RS512,
-			jose.ES256,
-			jose.ES384,
-			jose.ES512,
-			jose.PS256,
-			jose.PS384,
-			jose.PS512,
-		})
-
+		claims, err := a.VerifyFor(token, r)
 		if err != nil {
-			slog.Debug("Failed to parse signed token", "error", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			reason := m.ReasonValueTokenSignature
+			var tokenErr *TokenError
+			if errors.As(err, &tokenErr) {
+				reason = tokenErr.Reason
+			}
+			countRejected(reason)
+			slog.Warn("Rejected JWT", "reason", reason, "error", err)
+			if reason == m.ReasonValueTokenForbidden {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+			} else {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}
 			return
 		}
 
-		t, err := signature.Verify(a.keys)
-		if err != nil {
-			slog.Debug("Failed to verify signed token", "error", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		ctx := context.WithValue(r.Context(), JwtClaimsKey{}, claims)
+		if marker != "" {
+			ctx = context.WithValue(ctx, SubprotocolMarkerKey{}, marker)
 		}
-
-		claims := make(map[string]interface{})
-		if err := json.Unmarshal(t, &claims); err != nil {
-			slog.Debug("Failed to unmarshal claims", "error", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+		r = r.WithContext(ctx)
+		if marker != "" {
+			a.stripTokenSubprotocol(r, token)
 		}
 
-		// Validate issuer if configured
-		if a.issuer != "" {
-			if iss, ok := claims["iss"].(string); !ok || iss != a.issuer {
-				slog.Error("Invalid issuer", "issuer", iss, "expected", a.issuer)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// VerifyFor verifies the token and evaluates the claim rules with the upgrade request
+// Tokens denied by the claim rules are reported with a *TokenError with the forbidden reason
+func (a *JwtAuthorizer) VerifyFor(token string, r *http.Request) (map[string]interface{}, error) {
+	claims, err := a.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.authorizeClaims(claims, r); err != nil {
+		return nil, &TokenError{Reason: m.ReasonValueTokenForbidden, Err: err}
+	}
+
+	return claims, nil
+}
+
+// Verify verifies the signature and the registered claims of the token and returns its claims
+// Rejected tokens are reported with a *TokenError
+func (a *JwtAuthorizer) Verify(token string) (map[string]interface{}, error) {
+	signature, err := jose.ParseSigned(token, []jose.SignatureAlgorithm{
+		jose.EdDSA,
+		jose.HS256,
+		jose.HS384,
+		jose.HS512,
+		jose.RS256,
+		jose.RS384,
+		jose.RS512,
+		jose.ES256,
+		jose.ES384,
+		jose.ES512,
+		jose.PS256,
+		jose.PS384,
+		jose.PS512,
+	})
+
+	if err != nil {
+		slog.Debug("Failed to parse signed token", "error", err)
+		return nil, &TokenError{Reason: m.ReasonValueTokenMalformed, Err: err}
+	}
+
+	var keyId string
+	if len(signature.Signatures) > 0 {
+		keyId = signature.Signatures[0].Header.KeyID
+	}
+	keys, err := a.getKeys(keyId)
+	if err != nil {
+		slog.Debug("Failed to get keys", "error", err)
+		return nil, &TokenError{Reason: m.ReasonValueTokenSignature, Err: err}
+	}
+
+	t, err := signature.Verify(keys)
+	if err != nil {
+		slog.Debug("Failed to verify signed token", "error", err)
+		return nil, &TokenError{Reason: m.ReasonValueTokenSignature, Err: err}
+	}
+
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(t, &claims); err != nil {
+		slog.Debug("Failed to unmarshal claims", "error", err)
+		return nil, &TokenError{Reason: m.ReasonValueTokenMalformed, Err: err}
+	}
+
+	if err := a.validateTimes(claims, time.Now()); err != nil {
+		slog.Debug("Invalid token time claims", "error", err)
+		return nil, err
+	}
+
+	// Validate issuer if configured
+	if a.issuer != "" {
+		if iss, ok := claims["iss"].(string); !ok || iss != a.issuer {
+			slog.Debug("Invalid issuer", "issuer", iss, "expected", a.issuer)
+			return nil, rejectToken(m.ReasonValueTokenIssuer, "invalid issuer")
 		}
+	}
 
-		// Validate audience if configured
-		if a.audience != "" {
-			if aud, ok := claims["aud"]; ok {
-				// Handle both string and []string audience formats
-				switch v := aud.(type) {
-				case string:
-					if v != a.audience {
-						slog.Debug("Invalid audience", "audience", v, "expected", a.audience)
-						http.Error(w, "Unauthorized", http.StatusUnauthorized)
-						return
-					}
-				case []interface{}:
-					found := false
-					for _, aud := range v {
-						if str, ok := aud.(string); ok && str == a.audience {
-							found = true
-							break
-						}
-					}
-					if !found {
-						slog.Debug("Invalid audience", "audience", v, "expected", a.audience)
-						http.Error(w, "Unauthorized", http.StatusUnauthorized)
-						return
+	// Validate audience if configured
+	if a.audience != "" {
+		if aud, ok := claims["aud"]; ok {
+			// Handle both string and []string audience formats
+			switch v := aud.(type) {
+			case string:
+				if v != a.audience {
+					slog.Debug("Invalid audience", "audience", v, "expected", a.audience)
+					return nil, rejectToken(m.ReasonValueTokenAudience, "invalid audience")
+				}
+			case []interface{}:
+				found := false
+				for _, aud := range v {
+					if str, ok := aud.(string); ok && str == a.audience {
+						found = true
+						break
 					}
-				default:
+				}
+				if !found {
 					slog.Debug("Invalid audience", "audience", v, "expected", a.audience)
-					http.Error(w, "Unauthorized", http.StatusUnauthorized)
-					return
+					return nil, rejectToken(m.ReasonValueTokenAudience, "invalid audience")
 				}
-			} else {
-				slog.Debug("Missing audience", "audience", a.audience)
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
+			default:
+				slog.Debug("Invalid audience", "audience", v, "expected", a.audience)
+				return nil, rejectToken(m.ReasonValueTokenAudience, "invalid audience")
 			}
+		} else {
+			slog.Debug("Missing audience", "audience", a.audience)
+			return nil, rejectToken(m.ReasonValueTokenAudience, "missing audience")
 		}
+	}
 
-		ctx := context.WithValue(r.Context(), JwtClaimsKey{}, claims)
-		r = r.WithContext(ctx)
+	if a.requireSubject {
+		if sub, ok := claims["sub"].(string); !ok || sub == "" {
+			slog.Debug("Missing subject")
+			return nil, rejectToken(m.ReasonValueTokenSubject, "missing subject")
+		}
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return claims, nil
+}
+
+// getKeys returns the keys to verify a token signed with the given key ID
+// Refreshing providers are asked to refetch the keys if the key ID is unknown, as the keys may have been rotated
+func (a *JwtAuthorizer) getKeys(keyId string) (*jose.JSONWebKeySet, error) {
+	if a.refresher == nil {
+		return a.keys, nil
+	}
+
+	keys, err := a.refresher.GetKeys()
+	if err == nil && (keyId == "" || len(keys.Key(keyId)) > 0) {
+		return keys, nil
+	}
+
+	return a.refresher.Refresh()
+}
+
+func countRejected(reason string) {
+	m.JwtRejectedCounter.With(prometheus.Labels{
+		m.ReasonLabel: reason,
+	}).Inc()
 }
@@ -4,13 +4,16 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-jose/go-jose/v4"
 	"github.com/go-jose/go-jose/v4/jwt"
 	"github.com/stretchr/testify/assert"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
 )
 
 var (
@@ -345,6 +348,208 @@ func TestJwtAuthorizer(t *testing.T) {
 	})
 }
 
+func TestJwtAuthorizerTokenSources(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	jwks := &jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:   &privateKey.PublicKey,
+				Use:   "sig",
+				KeyID: testKeyID,
+			},
+		},
+	}
+
+	config := &JwtConfig{
+		QueryParam:   "token",
+		TokenSources: []TokenSource{TokenSourceHeader, TokenSourceCookie, TokenSourceSubprotocol},
+		CookieName:   "session",
+		SecretSource: &RawJWKSProvider{Content: mustMarshal(t, jwks)},
+	}
+
+	authorizer, err := NewJwtAuthorizer(config)
+	assert.NoError(t, err)
+
+	var handled *http.Request
+	middleware := authorizer.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	token := createToken(t, privateKey, testKeyID, jose.RS256)
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewJwtAuthorizer(&JwtConfig{TokenSources: []TokenSource{"unknown"}})
+		assert.Error(t, err)
+		_, err = NewJwtAuthorizer(&JwtConfig{TokenSources: []TokenSource{TokenSourceCookie}})
+		assert.Error(t, err, "cookie source requires a cookie name")
+	})
+
+	t.Run("query param not in sources", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/?token="+token, nil)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, handled)
+	})
+
+	t.Run("authorization header", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		if assert.NotNil(t, handled) {
+			assert.Nil(t, handled.Context().Value(SubprotocolMarkerKey{}))
+		}
+	})
+
+	t.Run("authorization header scheme is case-insensitive", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "bearer "+token)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("sources tried in order", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer invalid")
+		req.AddCookie(&http.Cookie{Name: "session", Value: token})
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "the first token found is verified")
+		assert.Nil(t, handled)
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: token})
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotNil(t, handled)
+	})
+
+	t.Run("subprotocol", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "mqtt, bearer, "+token)
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		if assert.NotNil(t, handled) {
+			assert.Equal(t, "bearer", handled.Context().Value(SubprotocolMarkerKey{}))
+			assert.Equal(t, "mqtt", handled.Header.Get("Sec-WebSocket-Protocol"), "marker and token should be removed")
+		}
+		assert.Equal(t, "mqtt, bearer, "+token, req.Header.Get("Sec-WebSocket-Protocol"), "original request should not change")
+	})
+
+	t.Run("subprotocol marker without token", func(t *testing.T) {
+		handled = nil
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Sec-WebSocket-Protocol", "mqtt, bearer")
+		w := httptest.NewRecorder()
+
+		middleware.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Nil(t, handled)
+	})
+}
+
+func TestJwtAuthorizerRegisteredClaims(t *testing.T) {
+	key := []byte("registered-claims-key-32-bytes!!")
+	config := &JwtConfig{
+		QueryParam: "token",
+		SecretSource: &RawJWKSProvider{Content: mustMarshal(t, &jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: key, Use: "sig", Algorithm: string(jose.HS256), KeyID: testKeyID}},
+		})},
+		Leeway:         30 * time.Second,
+		MaxTokenAge:    time.Hour,
+		RequireSubject: true,
+	}
+
+	authorizer, err := NewJwtAuthorizer(config)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		reason string
+	}{
+		{"valid", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}, ""},
+		{"expired within leeway", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": now.Add(-10 * time.Second).Unix()}, ""},
+		{"expired", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": now.Add(-time.Minute).Unix()}, m.ReasonValueTokenExpired},
+		{"not yet valid", map[string]interface{}{"sub": "user", "iat": now.Unix(), "nbf": now.Add(time.Minute).Unix()}, m.ReasonValueTokenNotYetValid},
+		{"issued in future", map[string]interface{}{"sub": "user", "iat": now.Add(time.Minute).Unix()}, m.ReasonValueTokenIssuedInFuture},
+		{"too old", map[string]interface{}{"sub": "user", "iat": now.Add(-2 * time.Hour).Unix()}, m.ReasonValueTokenTooOld},
+		{"missing iat", map[string]interface{}{"sub": "user"}, m.ReasonValueTokenTooOld},
+		{"missing subject", map[string]interface{}{"iat": now.Unix()}, m.ReasonValueTokenSubject},
+		{"invalid exp", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": "tomorrow"}, m.ReasonValueTokenClaims},
+		{"far future exp", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": 9999999999}, ""},
+		{"far future nbf", map[string]interface{}{"sub": "user", "iat": now.Unix(), "nbf": 1e10}, m.ReasonValueTokenNotYetValid},
+		{"far future iat", map[string]interface{}{"sub": "user", "iat": 9999999999}, m.ReasonValueTokenIssuedInFuture},
+		{"exp out of range", map[string]interface{}{"sub": "user", "iat": now.Unix(), "exp": 1e300}, m.ReasonValueTokenClaims},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token := createTokenWithClaims(t, key, testKeyID, jose.HS256, test.claims)
+			_, err := authorizer.Verify(token)
+			if test.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var tokenErr *TokenError
+			if assert.ErrorAs(t, err, &tokenErr) {
+				assert.Equal(t, test.reason, tokenErr.Reason)
+			}
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewJwtAuthorizer(&JwtConfig{Leeway: -time.Second})
+		assert.Error(t, err)
+		_, err = NewJwtAuthorizer(&JwtConfig{MaxTokenAge: -time.Second})
+		assert.Error(t, err)
+	})
+}
+
+func TestParseNumericDate(t *testing.T) {
+	date, err := ParseNumericDate(9999999999)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9999999999), date.Unix())
+
+	date, err = ParseNumericDate(1700000000.5)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, int64(time.Second/2)), date)
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1e19, -1e19} {
+		_, err := ParseNumericDate(value)
+		assert.Error(t, err, "%v should be rejected", value)
+	}
+}
+
 func TestJwtAuthorizerWithDifferentAlgorithms(t *testing.T) {
 	hmacKey := make([]byte, 32)
 	_, err := rand.Read(hmacKey)
@@ -0,0 +1,207 @@
+package jwt
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RuleOperator selects how a claim is compared in a ClaimRule
+type RuleOperator string
+
+const (
+	// RuleEquals requires the claim to equal the value
+	RuleEquals RuleOperator = "equals"
+	// RuleContains requires an array claim to contain the value, or a space-separated
+	// string claim (e.g. scope) to contain it as one of its words
+	RuleContains RuleOperator = "contains"
+	// RuleRegex requires the whole claim to match the regular expression in the value
+	RuleRegex RuleOperator = "regex"
+	// RuleIn requires the claim, or an element of an array claim, to be one of the values
+	RuleIn RuleOperator = "in"
+)
+
+// ClaimRule allows an upgrade only if a claim of the token satisfies the operator
+// Values of equals, contains and in rules may reference the upgrade request:
+// {query:name} is replaced with the query parameter and {path:name} with the path variable
+type ClaimRule struct {
+	// Claim is the claim name, nested claims are separated by dots (e.g. realm_access.roles)
+	Claim string `json:"claim"`
+	// Operator selects the comparison (equals, contains, regex, in)
+	Operator RuleOperator `json:"operator"`
+	// Value is compared with the claim (equals, contains, regex)
+	Value string `json:"value,omitempty"`
+	// Values lists the allowed claim values (in)
+	Values []string `json:"values,omitempty"`
+}
+
+// referencePattern matches references to the upgrade request in rule values
+var referencePattern = regexp.MustCompile(`\{(query|path):([^{}]+)\}`)
+
+// claimRule is a validated ClaimRule
+type claimRule struct {
+	ClaimRule
+	regex *regexp.Regexp
+}
+
+// compileRules validates the claim rules and compiles their regular expressions
+func compileRules(rules []ClaimRule) ([]claimRule, error) {
+	compiled := make([]claimRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Claim == "" {
+			return nil, fmt.Errorf("claim rule without claim name")
+		}
+
+		c := claimRule{ClaimRule: rule}
+		switch rule.Operator {
+		case RuleEquals:
+		case RuleContains:
+			if rule.Value == "" {
+				return nil, fmt.Errorf("claim rule on %s: contains requires a value", rule.Claim)
+			}
+		case RuleRegex:
+			// anchor the expression so that a claim merely containing a match is not accepted
+			regex, err := regexp.Compile(`^(?:` + rule.Value + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("claim rule on %s: invalid regular expression: %w", rule.Claim, err)
+			}
+			c.regex = regex
+		case RuleIn:
+			if len(rule.Values) == 0 {
+				return nil, fmt.Errorf("claim rule on %s: in requires values", rule.Claim)
+			}
+		default:
+			return nil, fmt.Errorf("claim rule on %s: unknown operator: %s", rule.Claim, rule.Operator)
+		}
+		compiled = append(compiled, c)
+	}
+
+	return compiled, nil
+}
+
+// authorizeClaims evaluates the claim rules, all of which must be satisfied
+// Returns an error describing the first rule the claims do not satisfy
+func (a *JwtAuthorizer) authorizeClaims(claims map[string]interface{}, r *http.Request) error {
+	for _, rule := range a.claimRules {
+		value, ok := claimValue(claims, rule.Claim)
+		if !ok {
+			return fmt.Errorf("missing claim %s", rule.Claim)
+		}
+
+		if !rule.matches(value, r) {
+			return fmt.Errorf("claim %s does not satisfy %s rule", rule.Claim, rule.Operator)
+		}
+	}
+
+	return nil
+}
+
+func (c *claimRule) matches(value interface{}, r *http.Request) bool {
+	switch c.Operator {
+	case RuleEquals:
+		expected, ok := resolveReferences(c.Value, r)
+		claim, isScalar := scalarString(value)
+		return ok && isScalar && claim == expected
+	case RuleContains:
+		expected, ok := resolveReferences(c.Value, r)
+		if !ok {
+			return false
+		}
+		if claim, isString := value.(string); isString {
+			return slices.Contains(strings.Fields(claim), expected)
+		}
+		return slices.Contains(arrayStrings(value), expected)
+	case RuleRegex:
+		claim, ok := scalarString(value)
+		return ok && c.regex.MatchString(claim)
+	case RuleIn:
+		allowed := make([]string, 0, len(c.Values))
+		for _, v := range c.Values {
+			if resolved, ok := resolveReferences(v, r); ok {
+				allowed = append(allowed, resolved)
+			}
+		}
+		if claim, ok := scalarString(value); ok {
+			return slices.Contains(allowed, claim)
+		}
+		for _, claim := range arrayStrings(value) {
+			if slices.Contains(allowed, claim) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// resolveReferences replaces {query:name} and {path:name} with the values of the upgrade request
+// Returns false if a referenced parameter is missing or empty
+func resolveReferences(value string, r *http.Request) (string, bool) {
+	resolvedAll := true
+	resolved := referencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		match := referencePattern.FindStringSubmatch(reference)
+		var parameter string
+		if match[1] == "query" {
+			parameter = r.URL.Query().Get(match[2])
+		} else {
+			parameter = mux.Vars(r)[match[2]]
+		}
+		if parameter == "" {
+			resolvedAll = false
+		}
+		return parameter
+	})
+
+	return resolved, resolvedAll
+}
+
+// claimValue looks up a claim, following dots into nested objects unless the claim name itself contains them
+func claimValue(claims map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := claims[name]; ok {
+		return value, true
+	}
+
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[part]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// scalarString formats string, number and boolean claims
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+// arrayStrings returns the scalar elements of an array claim
+func arrayStrings(value interface{}) []string {
+	array, _ := value.([]interface{})
+	values := make([]string, 0, len(array))
+	for _, element := range array {
+		if v, ok := scalarString(element); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJwtAuthorizerClaimRules(t *testing.T) {
+	key := []byte("claim-rules-secret-key-32-bytes!")
+	authorizer, err := NewJwtAuthorizer(&JwtConfig{
+		QueryParam: "token",
+		SecretSource: &RawJWKSProvider{Content: mustMarshal(t, &jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: key, Use: "sig", Algorithm: string(jose.HS256), KeyID: testKeyID}},
+		})},
+		ClaimRules: []ClaimRule{
+			{Claim: "scope", Operator: RuleContains, Value: "ws:connect"},
+			{Claim: "realm_access.roles", Operator: RuleIn, Values: []string{"admin", "user"}},
+			{Claim: "tenant", Operator: RuleEquals, Value: "{path:tenant}"},
+			{Claim: "email", Operator: RuleRegex, Value: `[^@]+@example\.com`},
+		},
+	})
+	assert.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.Path("/tenants/{tenant}").Handler(authorizer.Authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	allowed := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub":          "user-42",
+			"scope":        "profile ws:connect",
+			"realm_access": map[string]interface{}{"roles": []string{"viewer", "user"}},
+			"tenant":       "acme",
+			"email":        "user@example.com",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		modify func(claims map[string]interface{})
+		status int
+	}{
+		{"all rules satisfied", "/tenants/acme", func(map[string]interface{}) {}, http.StatusOK},
+		{"missing scope", "/tenants/acme", func(c map[string]interface{}) { c["scope"] = "profile" }, http.StatusForbidden},
+		{"scope as array", "/tenants/acme", func(c map[string]interface{}) { c["scope"] = []string{"ws:connect"} }, http.StatusOK},
+		{"role not allowed", "/tenants/acme", func(c map[string]interface{}) {
+			c["realm_access"] = map[string]interface{}{"roles": []string{"viewer"}}
+		}, http.StatusForbidden},
+		{"other tenant", "/tenants/other", func(map[string]interface{}) {}, http.StatusForbidden},
+		{"email not matching", "/tenants/acme", func(c map[string]interface{}) { c["email"] = "user@example.org" }, http.StatusForbidden},
+		{"email partially matching", "/tenants/acme", func(c map[string]interface{}) { c["email"] = "user@example.com.evil.org" }, http.StatusForbidden},
+		{"missing claim", "/tenants/acme", func(c map[string]interface{}) { delete(c, "tenant") }, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := allowed()
+			test.modify(claims)
+			token := createTokenWithClaims(t, key, testKeyID, jose.HS256, claims)
+			req := httptest.NewRequest("GET", test.path+"?token="+token, nil)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+
+	t.Run("invalid token is unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/tenants/acme?token=invalid", nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestClaimRuleQueryReference(t *testing.T) {
+	rule := claimRule{ClaimRule: ClaimRule{Claim: "tenant", Operator: RuleEquals, Value: "{query:tenant}"}}
+
+	assert.True(t, rule.matches("acme", httptest.NewRequest("GET", "/?tenant=acme", nil)))
+	assert.False(t, rule.matches("acme", httptest.NewRequest("GET", "/?tenant=other", nil)))
+	assert.False(t, rule.matches("", httptest.NewRequest("GET", "/", nil)), "missing parameters should not match")
+}
+
+func TestCompileRules(t *testing.T) {
+	_, err := compileRules([]ClaimRule{{Claim: "role", Operator: RuleIn, Values: []string{"admin"}}})
+	assert.NoError(t, err)
+
+	for _, rule := range []ClaimRule{
+		{Operator: RuleEquals, Value: "x"},
+		{Claim: "role", Operator: "unknown", Value: "x"},
+		{Claim: "role", Operator: RuleIn},
+		{Claim: "scope", Operator: RuleContains},
+		{Claim: "email", Operator: RuleRegex, Value: "("},
+	} {
+		_, err := compileRules([]ClaimRule{rule})
+		assert.Error(t, err, "rule %+v should be invalid", rule)
+	}
+}
@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// TokenSource identifies where the token of an upgrade request is looked up
+type TokenSource string
+
+const (
+	// TokenSourceQuery reads the token from the configured query parameter
+	TokenSourceQuery TokenSource = "query"
+	// TokenSourceHeader reads the token from the Authorization: Bearer header
+	TokenSourceHeader TokenSource = "header"
+	// TokenSourceCookie reads the token from the configured cookie
+	TokenSourceCookie TokenSource = "cookie"
+	// TokenSourceSubprotocol reads the token from the Sec-WebSocket-Protocol header, where it follows the marker
+	// (e.g. Sec-WebSocket-Protocol: bearer, <token>), as browsers cannot set headers on WebSocket upgrades
+	TokenSourceSubprotocol TokenSource = "subprotocol"
+)
+
+const defaultSubprotocolMarker = "bearer"
+
+// SubprotocolMarkerKey is the context key of the subprotocol marker of tokens read from Sec-WebSocket-Protocol
+// The marker must be echoed as the subprotocol of the upgrade unless another subprotocol is negotiated
+type SubprotocolMarkerKey struct{}
+
+// validateTokenSources checks the token sources of the configuration
+// Returns an error if a source is unknown or the cookie source has no cookie name
+func validateTokenSources(config *JwtConfig) error {
+	for _, source := range config.TokenSources {
+		switch source {
+		case TokenSourceQuery, TokenSourceHeader, TokenSourceSubprotocol:
+		case TokenSourceCookie:
+			if config.CookieName == "" {
+				return fmt.Errorf("cookie name is required by the cookie token source")
+			}
+		default:
+			return fmt.Errorf("unknown token source: %s", source)
+		}
+	}
+
+	return nil
+}
+
+// extractToken looks up the token in the configured sources
+// Returns the token and the subprotocol marker if the token was read from Sec-WebSocket-Protocol
+func (a *JwtAuthorizer) extractToken(r *http.Request) (string, string) {
+	for _, source := range a.tokenSources {
+		switch source {
+		case TokenSourceQuery:
+			if token := r.URL.Query().Get(a.queryParam); token != "" {
+				return token, ""
+			}
+		case TokenSourceHeader:
+			if token, ok := BearerToken(r); ok {
+				return token, ""
+			}
+		case TokenSourceCookie:
+			if cookie, err := r.Cookie(a.cookieName); err == nil && cookie.Value != "" {
+				return cookie.Value, ""
+			}
+		case TokenSourceSubprotocol:
+			protocols := websocket.Subprotocols(r)
+			for i, protocol := range protocols {
+				if protocol == a.subprotocolMarker && i+1 < len(protocols) {
+					return protocols[i+1], a.subprotocolMarker
+				}
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// stripTokenSubprotocol removes the marker and the token from the Sec-WebSocket-Protocol header,
+// so neither is negotiated nor forwarded to the backend as a requested subprotocol
+// The request headers are copied before they are modified
+func (a *JwtAuthorizer) stripTokenSubprotocol(r *http.Request, token string) {
+	protocols := make([]string, 0)
+	for _, protocol := range websocket.Subprotocols(r) {
+		if protocol != a.subprotocolMarker && protocol != token {
+			protocols = append(protocols, protocol)
+		}
+	}
+
+	r.Header = r.Header.Clone()
+	r.Header.Del("Sec-Websocket-Protocol")
+	if len(protocols) > 0 {
+		r.Header.Set("Sec-Websocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+// BearerToken returns the token of the Authorization header with the Bearer scheme (case-insensitive)
+// Returns false if the header is missing, uses another scheme or has no token
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
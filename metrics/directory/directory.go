@@ -18,6 +18,66 @@ var (
 		Help:      "Connect events counter",
 	})
 
+	OriginRejectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "origin_rejected_total",
+		Help:      "The number of WebSocket upgrades rejected because of a disallowed origin",
+	})
+
+	AdmissionRejectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "admission_rejected_total",
+		Help:      "The number of WebSocket upgrades rejected by the backend before the upgrade",
+	})
+
+	SessionReplacedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "session_replaced_total",
+		Help:      "The number of sessions closed because a new connection took over their session ID",
+	})
+
+	DuplicateSessionRejectedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "duplicate_session_rejected_total",
+		Help:      "The number of WebSocket upgrades rejected because their session ID was in use",
+	})
+
+	MessageRejectedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "message_rejected_total",
+		Help:      "The number of messages rejected because of their size or invalid UTF-8 text",
+	}, []string{ReasonLabel})
+
+	JwtRejectedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "jwt_rejected_total",
+		Help:      "The number of WebSocket upgrades rejected because of a missing or invalid JWT",
+	}, []string{ReasonLabel})
+
+	SessionExpiredCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "session_expired_total",
+		Help:      "The number of sessions closed because their JWT expired",
+	})
+
+	TokenRefreshCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "token_refresh_total",
+		Help:      "The number of in-band JWT refreshes sent by clients",
+	}, []string{ResultLabel})
+
+	JwksRefreshCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "jwks_refresh_total",
+		Help:      "The number of successful JWKS fetches of cached JWT keys",
+	})
+
+	JwksRefreshFailureCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "jwks_refresh_failures_total",
+		Help:      "The number of failed JWKS fetches of cached JWT keys",
+	})
+
 	DisconnectCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: "ws2wh",
 		Name:      "disconnects_total",
@@ -35,10 +95,88 @@ var (
 		Name:      "message_failure_total",
 		Help:      "Failed message delivery counter",
 	}, []string{OriginLabel})
+
+	MessageRetryCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "message_retry_total",
+		Help:      "Retried message delivery counter",
+	}, []string{OriginLabel})
+
+	MessageFinalFailureCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "message_final_failure_total",
+		Help:      "Message delivery failures after the last retry attempt counter",
+	}, []string{OriginLabel})
+
+	TopicSubscribersGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ws2wh",
+		Name:      "topic_subscribers",
+		Help:      "The number of sessions subscribed to a topic",
+	}, []string{TopicLabel})
+
+	DeliveryQueueGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ws2wh",
+		Name:      "delivery_queue_messages",
+		Help:      "The number of client messages waiting for delivery to the backend",
+	})
+
+	DeliveryInFlightGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ws2wh",
+		Name:      "delivery_in_flight",
+		Help:      "The number of client messages being delivered to the backend",
+	})
+
+	OutboundQueueGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "ws2wh",
+		Name:      "outbound_queue_messages",
+		Help:      "The number of messages waiting to be written to WebSocket clients",
+	})
+
+	SlowConsumerCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "slow_consumer_total",
+		Help:      "The number of messages that found the outbound queue of a client full",
+	}, []string{PolicyLabel})
+
+	BackpressureCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ws2wh",
+		Name:      "delivery_backpressure_total",
+		Help:      "The number of client messages that found the delivery queue full",
+	}, []string{PolicyLabel})
 )
 
 const (
+	ReasonLabel        = "reason"
+	TopicLabel         = "topic"
+	PolicyLabel        = "policy"
 	OriginLabel        = "origin"
+	ResultLabel        = "result"
 	OriginValueBackend = "backend"
 	OriginValueClient  = "client"
+
+	OriginValueTimeout      = "timeout"
+	OriginValueServer       = "server"
+	OriginValueShutdown     = "server-shutdown"
+	OriginValueTokenExpired = "token-expired"
+
+	ReasonValueInboundTooLarge     = "inbound-too-large"
+	ReasonValueOutboundTooLarge    = "outbound-too-large"
+	ReasonValueInboundInvalidUtf8  = "inbound-invalid-utf8"
+	ReasonValueOutboundInvalidUtf8 = "outbound-invalid-utf8"
+
+	ReasonValueTokenMissing        = "missing"
+	ReasonValueTokenMalformed      = "malformed"
+	ReasonValueTokenSignature      = "invalid-signature"
+	ReasonValueTokenIssuer         = "invalid-issuer"
+	ReasonValueTokenAudience       = "invalid-audience"
+	ReasonValueTokenClaims         = "invalid-claims"
+	ReasonValueTokenExpired        = "expired"
+	ReasonValueTokenNotYetValid    = "not-yet-valid"
+	ReasonValueTokenIssuedInFuture = "issued-in-future"
+	ReasonValueTokenTooOld         = "too-old"
+	ReasonValueTokenSubject        = "missing-subject"
+	ReasonValueTokenForbidden      = "forbidden"
+
+	ResultValueAccepted = "accepted"
+	ResultValueRejected = "rejected"
 )
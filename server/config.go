@@ -4,21 +4,39 @@ import (
 	"fmt"
 	"log/slog"
 	"net/url"
+	"time"
 
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/frontend"
 	"github.com/ws2wh/ws2wh/http-middleware/jwt"
 	"github.com/ws2wh/ws2wh/metrics"
+	"github.com/ws2wh/ws2wh/session"
 )
 
 // Config holds the server configuration parameters
 type Config struct {
 	// BackendUrl is the webhook backend URL that will receive POST requests
 	BackendUrl string
+	// BackendConfig holds the webhook backend configuration parameters (optional)
+	BackendConfig *backend.WebhookConfig
+	// DeliveryConfig holds the client to backend message delivery parameters (optional)
+	DeliveryConfig *session.DeliveryConfig
 	// ReplyChannelConfig holds the reply channel configuration parameters
 	ReplyChannelConfig *ReplyChannelConfig
 	// WebSocketListener is the address and port for WebSocket server to listen on (default: :3000)
 	WebSocketListener string
 	// WebSocketPath is the path where WebSocket connections will be upgraded (default: /)
 	WebSocketPath string
+	// FrontendConfig holds the WebSocket connection parameters (optional)
+	FrontendConfig *frontend.Config
+	// ForwardConfig holds the upgrade request data forwarded to the backend (optional)
+	ForwardConfig *ForwardConfig
+	// SessionIdConfig holds the session ID assignment parameters (optional; default: generated IDs)
+	SessionIdConfig *SessionIdConfig
+	// TokenExpiryConfig closes sessions when the client's JWT expires (optional; requires JWT authentication)
+	TokenExpiryConfig *session.TokenExpiryConfig
+	// ContextConfig selects the session context fields sent with each event (optional; default: all fields on every event)
+	ContextConfig *session.ContextConfig
 	// LogLevel sets the logging level (DEBUG, INFO, WARN, ERROR, OFF; default: INFO)
 	LogLevel slog.Level
 	// Hostname is used in the reply channel URL (default: localhost)
@@ -40,6 +58,24 @@ type TlsConfig struct {
 	TlsKeyPath string
 }
 
+// ForwardConfig holds the upgrade request data forwarded to the backend with every event
+type ForwardConfig struct {
+	// Headers lists the upgrade request headers forwarded as Ws-Header-<Name> (e.g. User-Agent, Origin, Cookie; default: none)
+	Headers []string
+	// TrustedProxies lists the IP addresses or CIDR ranges of proxies whose X-Forwarded-For header is trusted (default: none)
+	TrustedProxies []string
+}
+
+// SessionIdConfig holds the session ID assignment parameters
+// IDs are generated unless taken from a JWT claim or assigned by the backend in its client-connected response
+type SessionIdConfig struct {
+	// JwtClaim is the JWT claim used as session ID if the token contains it (optional)
+	JwtClaim string
+	// DuplicatePolicy selects what happens when an assigned session ID is already in use
+	// (reject, replace; default: reject)
+	DuplicatePolicy DuplicatePolicy
+}
+
 // ReplyChannelConfig holds the reply channel configuration parameters
 type ReplyChannelConfig struct {
 	// PathPrefix is the path prefix for the reply channel (default: /reply)
@@ -50,6 +86,42 @@ type ReplyChannelConfig struct {
 	Scheme string
 	// Port is the port for the reply channel (default: 3000)
 	Port string
+	// BroadcastPath is the path for delivering messages to all sessions (default: /broadcast; empty disables)
+	BroadcastPath string
+	// MulticastPath is the path for delivering messages to a list of sessions (default: /multicast; empty disables)
+	MulticastPath string
+	// TopicsPathPrefix is the path prefix for publishing messages to topic subscribers (default: /topics; empty disables)
+	TopicsPathPrefix string
+	// AuthConfig holds the reply channel authentication parameters (optional)
+	AuthConfig *ReplyAuthConfig
+}
+
+// ReplyAuthMode selects how calls to the reply channel are authenticated
+type ReplyAuthMode string
+
+const (
+	// ReplyAuthNone disables reply channel authentication
+	ReplyAuthNone ReplyAuthMode = "none"
+	// ReplyAuthBearer requires a shared token in the Authorization header
+	ReplyAuthBearer ReplyAuthMode = "bearer"
+	// ReplyAuthHmac requires an HMAC-SHA256 request signature
+	ReplyAuthHmac ReplyAuthMode = "hmac"
+	// ReplyAuthJwt requires a JWT bearer token in the Authorization header
+	ReplyAuthJwt ReplyAuthMode = "jwt"
+)
+
+// ReplyAuthConfig holds the reply channel authentication parameters
+type ReplyAuthConfig struct {
+	// Mode selects the authentication method (none, bearer, hmac, jwt; default: none)
+	Mode ReplyAuthMode
+	// BearerToken is the shared token expected in the Authorization header (bearer mode)
+	BearerToken string
+	// HmacSecret is the shared secret used to verify request signatures (hmac mode)
+	HmacSecret string
+	// HmacTolerance is the maximum allowed clock difference for signed requests (hmac mode; default: 5m)
+	HmacTolerance time.Duration
+	// JwtConfig holds the JWT configuration used to verify callers (jwt mode)
+	JwtConfig *jwt.JwtConfig
 }
 
 func (c *ReplyChannelConfig) GetReplyUrl() string {
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/ws2wh/ws2wh/session"
+)
+
+// maxFanoutWorkers limits the number of sessions a single broadcast, multicast or topic message is delivered to at once
+const maxFanoutWorkers = 64
+
+// DeliveryResult represents the outcome of delivering a message to a single session
+type DeliveryResult struct {
+	SessionId string `json:"sessionId"`
+	SessionResponse
+}
+
+// MulticastRequest is the JSON request body accepted by the multicast endpoint
+type MulticastRequest struct {
+	// SessionIds lists the sessions the message should be delivered to
+	SessionIds []string `json:"sessionIds"`
+	// Message is the payload sent to every listed session (optional)
+	Message string `json:"message"`
+	// Data is a binary payload sent instead of Message, base64 encoded in JSON (optional)
+	Data []byte `json:"data"`
+}
+
+// payload returns the message sent to the sessions
+// Returns an error if both the message and the data are set
+func (r *MulticastRequest) payload() ([]byte, error) {
+	if r.Data == nil {
+		return []byte(r.Message), nil
+	}
+	if r.Message != "" {
+		return nil, errors.New("multicast request must not contain both message and data")
+	}
+
+	return r.Data, nil
+}
+
+// broadcast delivers the request body to all active sessions
+func (s *Server) broadcast(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	opts, errCode := parseReplyOptions(r.Header)
+	if errCode != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: errCode})
+		return
+	}
+
+	sessions := s.listSessions()
+	ids := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		ids = append(ids, session.Id)
+	}
+
+	writeDeliveryResults(w, s.deliverAll(ids, body, opts))
+}
+
+// multicast delivers the message to the sessions listed in the JSON request body
+func (s *Server) multicast(w http.ResponseWriter, r *http.Request) {
+	var req MulticastRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	payload, err := req.payload()
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	opts, errCode := parseReplyOptions(r.Header)
+	if errCode != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: errCode})
+		return
+	}
+
+	writeDeliveryResults(w, s.deliverAll(req.SessionIds, payload, opts))
+}
+
+// deliverAll delivers the payload to the sessions concurrently and returns the results in the order of the IDs
+// Repeated IDs are delivered to once and reported once, at their first position
+// At most maxFanoutWorkers sessions are delivered to at the same time, so a slow client only occupies one worker:
+// with the block slow consumer policy, its Send waits for room in its outbound queue, which the writer frees
+// within the write wait or by closing the connection
+func (s *Server) deliverAll(ids []string, payload []byte, opts *replyOptions) []DeliveryResult {
+	ids = uniqueIds(ids)
+	results := make([]DeliveryResult, len(ids))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(len(ids), maxFanoutWorkers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = deliver(ids[i], s.getSession(ids[i]), payload, opts)
+			}
+		}()
+	}
+	for i := range ids {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// uniqueIds returns the IDs without repetitions, keeping the order of their first occurrence
+func uniqueIds(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+
+	return unique
+}
+
+// deliver sends the payload to the session and executes the reply command
+// Returns the delivery result for the session
+func deliver(id string, session *session.Session, payload []byte, opts *replyOptions) DeliveryResult {
+	if session == nil {
+		return DeliveryResult{SessionId: id, SessionResponse: SessionResponse{Success: false, Message: "NOT_FOUND"}}
+	}
+
+	if len(payload) > 0 {
+		err := session.Send(payload, opts.messageType)
+		if err != nil {
+			slog.Error("Error while sending message", "error", err, "sessionId", id)
+			return DeliveryResult{SessionId: id, SessionResponse: SessionResponse{Success: false, Message: sendErrorCode(err)}}
+		}
+	}
+
+	err := opts.execute(session)
+	if err != nil {
+		slog.Error("Error while executing command", "error", err, "command", opts.command, "sessionId", id)
+		return DeliveryResult{SessionId: id, SessionResponse: SessionResponse{Success: false, Message: "COMMAND_FAILED"}}
+	}
+
+	return DeliveryResult{SessionId: id, SessionResponse: SessionResponse{Success: true}}
+}
+
+func writeDeliveryResults(w http.ResponseWriter, results []DeliveryResult) {
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(SessionResponse{Success: true, Message: results})
+	if err != nil {
+		slog.Error("Error while sending response", "error", err)
+	}
+}
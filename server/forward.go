@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientMetadata extracts the client IP and the forwarded headers from upgrade requests
+type clientMetadata struct {
+	headers        []string
+	trustedProxies []netip.Prefix
+}
+
+// newClientMetadata creates the client metadata extractor for the given configuration
+// Returns an error if a trusted proxy is not a valid IP address or CIDR range
+func newClientMetadata(config *ForwardConfig) (*clientMetadata, error) {
+	c := clientMetadata{}
+	if config == nil {
+		return &c, nil
+	}
+
+	for _, name := range config.Headers {
+		if name = strings.TrimSpace(name); name != "" {
+			c.headers = append(c.headers, http.CanonicalHeaderKey(name))
+		}
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			c.trustedProxies = append(c.trustedProxies, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: expected an IP address or CIDR range", proxy)
+		}
+		addr = addr.Unmap()
+		c.trustedProxies = append(c.trustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return &c, nil
+}
+
+// forwardedHeaders returns the allowlisted headers of the request
+// Returns nil if no header is configured
+func (c *clientMetadata) forwardedHeaders(r *http.Request) http.Header {
+	if len(c.headers) == 0 {
+		return nil
+	}
+
+	h := make(http.Header, len(c.headers))
+	for _, name := range c.headers {
+		if values := r.Header.Values(name); len(values) > 0 {
+			h[name] = append([]string(nil), values...)
+		}
+	}
+
+	return h
+}
+
+// clientIp returns the IP address of the client
+// X-Forwarded-For is read from right to left while the connecting address is a trusted proxy,
+// the first untrusted address is the client
+func (c *clientMetadata) clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+
+	hops := make([]string, 0)
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && c.trusted(addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+	}
+
+	return addr.String()
+}
+
+func (c *clientMetadata) trusted(addr netip.Addr) bool {
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/frontend"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// limitBody limits reply channel request bodies to the maximum outbound message size
+// Requests announcing a larger body are rejected with 413 before the body is read
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	if s.frontendConfig == nil || s.frontendConfig.MaxOutboundMessageSize <= 0 {
+		return next
+	}
+
+	limit := s.frontendConfig.MaxOutboundMessageSize
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			writeMessageTooLarge(w)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBodyError answers a reply channel call whose body could not be read
+// Bodies exceeding the maximum outbound message size are answered with 413, other errors with 400
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeMessageTooLarge(w)
+		return
+	}
+
+	slog.Error("Error reading request body", "error", err)
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: "INVALID_REQUEST"})
+}
+
+func writeMessageTooLarge(w http.ResponseWriter) {
+	m.MessageRejectedCounter.With(prometheus.Labels{
+		m.ReasonLabel: m.ReasonValueOutboundTooLarge,
+	}).Inc()
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: "MESSAGE_TOO_LARGE"})
+}
+
+// sendErrorCode returns the error code of a message rejected by the connection
+// Returns SEND_FAILED for other errors
+func sendErrorCode(err error) string {
+	switch {
+	case errors.Is(err, frontend.ErrMessageTooLarge):
+		return "MESSAGE_TOO_LARGE"
+	case errors.Is(err, frontend.ErrInvalidUtf8):
+		return "INVALID_UTF8"
+	default:
+		return "SEND_FAILED"
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/http-middleware/jwt"
+)
+
+const defaultHmacTolerance = 5 * time.Minute
+
+// newReplyAuthMiddleware creates a middleware authenticating reply channel calls
+// according to the configured mode. Returns a pass-through middleware if
+// authentication is not configured.
+func newReplyAuthMiddleware(config *ReplyAuthConfig) (func(http.Handler) http.Handler, error) {
+	if config == nil || config.Mode == "" || config.Mode == ReplyAuthNone {
+		return func(next http.Handler) http.Handler { return next }, nil
+	}
+
+	var authenticate func(r *http.Request) (string, error)
+
+	switch config.Mode {
+	case ReplyAuthBearer:
+		if config.BearerToken == "" {
+			return nil, fmt.Errorf("reply channel bearer token is required")
+		}
+		authenticate = bearerAuthenticator(config.BearerToken)
+	case ReplyAuthHmac:
+		if config.HmacSecret == "" {
+			return nil, fmt.Errorf("reply channel HMAC secret is required")
+		}
+		tolerance := config.HmacTolerance
+		if tolerance <= 0 {
+			tolerance = defaultHmacTolerance
+		}
+		authenticate = hmacAuthenticator([]byte(config.HmacSecret), tolerance)
+	case ReplyAuthJwt:
+		if config.JwtConfig == nil {
+			return nil, fmt.Errorf("reply channel JWT configuration is required")
+		}
+		authorizer, err := jwt.NewJwtAuthorizer(config.JwtConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize reply channel JWT authorizer: %w", err)
+		}
+		authenticate = jwtAuthenticator(authorizer)
+	default:
+		return nil, fmt.Errorf("unknown reply channel auth mode: %s", config.Mode)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			code, err := authenticate(r)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+
+			if code != "" {
+				slog.Warn("Rejected reply channel call", "path", r.URL.Path, "reason", code)
+				w.WriteHeader(http.StatusUnauthorized)
+				err := json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: code})
+				if err != nil {
+					slog.Error("Error while sending response", "error", err)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// bearerAuthenticator accepts requests with the shared token in the Authorization header
+func bearerAuthenticator(expected string) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		token, ok := jwt.BearerToken(r)
+		if !ok {
+			return "UNAUTHORIZED", nil
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return "INVALID_TOKEN", nil
+		}
+
+		return "", nil
+	}
+}
+
+// hmacAuthenticator accepts requests signed with the shared secret
+// The signature is computed over the timestamp, the method, the request path, the control headers
+// and the request body (see backend.ReplySignatureParts)
+// Returns an error if the request body cannot be read, e.g. because it exceeds the size limit
+func hmacAuthenticator(secret []byte, tolerance time.Duration) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		err = backend.VerifySignature(
+			[][]byte{secret},
+			tolerance,
+			r.Header.Get(backend.SignatureHeader),
+			r.Header.Get(backend.TimestampHeader),
+			backend.ReplySignatureParts(r.Method, r.URL.Path, r.Header, body)...,
+		)
+
+		switch {
+		case err == nil:
+			return "", nil
+		case errors.Is(err, backend.ErrMissingSignature):
+			return "UNAUTHORIZED", nil
+		case errors.Is(err, backend.ErrSignatureExpired):
+			return "SIGNATURE_EXPIRED", nil
+		default:
+			return "INVALID_SIGNATURE", nil
+		}
+	}
+}
+
+// jwtAuthenticator accepts requests with a valid JWT in the Authorization header
+func jwtAuthenticator(authorizer *jwt.JwtAuthorizer) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		token, ok := jwt.BearerToken(r)
+		if !ok {
+			return "UNAUTHORIZED", nil
+		}
+
+		if _, err := authorizer.Verify(token); err != nil {
+			return "INVALID_TOKEN", nil
+		}
+
+		return "", nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/ws2wh/ws2wh/backend"
 	"github.com/ws2wh/ws2wh/frontend"
 	"github.com/ws2wh/ws2wh/http-middleware/jwt"
@@ -24,15 +26,23 @@ import (
 
 // Server handles WebSocket connections and forwards messages to a configured backend
 type Server struct {
-	DefaultBackend backend.Backend
-	frontendAddr   string
-	backendUrl     string
-	replyUrl       string
-	sessions       map[string]*session.Session
-	sessionsLock   sync.RWMutex
-	httpHandler    http.Handler
-	tlsCertPath    string
-	tlsKeyPath     string
+	DefaultBackend  backend.Backend
+	frontendAddr    string
+	backendUrl      string
+	replyUrl        string
+	sessions        map[string]*session.Session
+	sessionsLock    sync.RWMutex
+	topics          *topicRegistry
+	delivery        *session.DeliveryConfig
+	context         *session.ContextConfig
+	frontendConfig  *frontend.Config
+	clientMetadata  *clientMetadata
+	sessionIdConfig *SessionIdConfig
+	tokenExpiry     *session.TokenExpiryConfig
+	authorizer      *jwt.JwtAuthorizer
+	httpHandler     http.Handler
+	tlsCertPath     string
+	tlsKeyPath      string
 }
 
 // CreateServerWithConfig initializes a new Server instance with the given configuration
@@ -40,36 +50,142 @@ type Server struct {
 // Parameters:
 //   - config: A pointer to a Config struct containing the server configuration
 //
-// # Returns a configured Server instance ready to be started
-func CreateServerWithConfig(config *Config) *Server {
+// # Returns a configured Server instance ready to be started, or an error if any
+// of the configured components (e.g. JWT key provider) failed to initialize
+func CreateServerWithConfig(config *Config) (*Server, error) {
 	s := Server{
-		frontendAddr: config.WebSocketListener,
-		backendUrl:   config.BackendUrl,
-		replyUrl:     config.ReplyChannelConfig.GetReplyUrl(),
-		sessions:     make(map[string]*session.Session, 100),
-		tlsCertPath:  config.TlsConfig.TlsCertPath,
-		tlsKeyPath:   config.TlsConfig.TlsKeyPath,
+		frontendAddr:    config.WebSocketListener,
+		backendUrl:      config.BackendUrl,
+		replyUrl:        config.ReplyChannelConfig.GetReplyUrl(),
+		sessions:        make(map[string]*session.Session, 100),
+		topics:          newTopicRegistry(),
+		delivery:        config.DeliveryConfig,
+		context:         config.ContextConfig,
+		frontendConfig:  config.FrontendConfig,
+		sessionIdConfig: config.SessionIdConfig,
+		tokenExpiry:     config.TokenExpiryConfig,
+		tlsCertPath:     config.TlsConfig.TlsCertPath,
+		tlsKeyPath:      config.TlsConfig.TlsKeyPath,
 	}
 
-	s.initMux(config)
-	s.DefaultBackend = backend.CreateBackend(config.BackendUrl)
+	if config.DeliveryConfig != nil {
+		if err := config.DeliveryConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.ContextConfig != nil {
+		if err := config.ContextConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.FrontendConfig != nil {
+		if err := config.FrontendConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc := config.FrontendConfig; fc == nil || (fc.OriginPolicy == "" && len(fc.AllowedOrigins) == 0) {
+		slog.Warn("No WebSocket origin policy configured, only same-origin upgrades are accepted; " +
+			"set -ws-allowed-origins or -ws-origin-policy allow-all to accept browser clients from other hosts")
+	}
+
+	if config.SessionIdConfig != nil {
+		if err := config.SessionIdConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.TokenExpiryConfig != nil {
+		if config.JwtConfig == nil || !config.JwtConfig.Enabled {
+			return nil, fmt.Errorf("token expiry requires JWT authentication")
+		}
+		if err := config.TokenExpiryConfig.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	clientMetadata, err := newClientMetadata(config.ForwardConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.clientMetadata = clientMetadata
+
+	err = s.initMux(config)
+	if err != nil {
+		return nil, err
+	}
+	s.DefaultBackend, err = backend.CreateBackend(config.BackendUrl, backendConfig(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize webhook backend: %w", err)
+	}
 
 	slog.Info("Starting server...",
 		"backendUrl", config.BackendUrl,
 		"websocketPath", config.WebSocketPath,
 		"frontendAddr", config.WebSocketListener,
+		"jwtEnabled", config.JwtConfig != nil && config.JwtConfig.Enabled,
 	)
 
-	return &s
+	return &s, nil
 }
 
-func (s *Server) initMux(config *Config) {
+// backendConfig returns the webhook configuration, limiting response bodies to the maximum outbound message size
+// unless a response size limit is configured
+func backendConfig(config *Config) *backend.WebhookConfig {
+	fc := config.FrontendConfig
+	if fc == nil || fc.MaxOutboundMessageSize <= 0 {
+		return config.BackendConfig
+	}
+
+	var c backend.WebhookConfig
+	if config.BackendConfig != nil {
+		c = *config.BackendConfig
+	}
+	if c.MaxResponseSize <= 0 {
+		c.MaxResponseSize = fc.MaxOutboundMessageSize
+	}
+	return &c
+}
+
+func (s *Server) initMux(config *Config) error {
 	router := mux.NewRouter()
-	router.Path(config.WebSocketPath).Methods("GET").HandlerFunc(s.handle)
+
+	var wsHandler http.Handler = http.HandlerFunc(s.handle)
+	if config.JwtConfig != nil && config.JwtConfig.Enabled {
+		authorizer, err := jwt.NewJwtAuthorizer(config.JwtConfig)
+		if err != nil {
+			return fmt.Errorf("failed to initialize JWT authorizer: %w", err)
+		}
+		wsHandler = authorizer.Authorize(wsHandler)
+		s.authorizer = authorizer
+	}
+
+	router.Path(config.WebSocketPath).Methods("GET").Handler(wsHandler)
+
+	replyAuth, err := newReplyAuthMiddleware(config.ReplyChannelConfig.AuthConfig)
+	if err != nil {
+		return err
+	}
+	reply := func(handler http.HandlerFunc) http.Handler {
+		return s.limitBody(replyAuth(handler))
+	}
 	replyPath := fmt.Sprintf("%s/{id}", strings.TrimRight(config.ReplyChannelConfig.PathPrefix, "/"))
-	router.Path(replyPath).Methods("POST").HandlerFunc(s.send)
+	router.Path(replyPath).Methods("POST").Handler(reply(s.send))
+	if config.ReplyChannelConfig.BroadcastPath != "" {
+		router.Path(config.ReplyChannelConfig.BroadcastPath).Methods("POST").Handler(reply(s.broadcast))
+	}
+	if config.ReplyChannelConfig.MulticastPath != "" {
+		router.Path(config.ReplyChannelConfig.MulticastPath).Methods("POST").Handler(reply(s.multicast))
+	}
+	if config.ReplyChannelConfig.TopicsPathPrefix != "" {
+		topicPath := fmt.Sprintf("%s/{name}", strings.TrimRight(config.ReplyChannelConfig.TopicsPathPrefix, "/"))
+		router.Path(topicPath).Methods("POST").Handler(reply(s.publish))
+	}
 
 	s.httpHandler = router
+	return nil
 }
 
 // Start begins listening for connections on the configured address
@@ -102,15 +218,60 @@ func (s *Server) Start(ctx context.Context) {
 		if err := server.Shutdown(shutdownCtx); err != nil {
 			slog.Warn("Error during gracefully server shutdown", "err", err)
 		}
+		s.shutdownSessions()
+		// sessions are closed, pending retries must not hold up the shutdown
+		if closer, ok := s.DefaultBackend.(io.Closer); ok {
+			closer.Close()
+		}
 	}()
 }
 
+// shutdownSessions closes all WebSocket sessions, which are not tracked by the HTTP server shutdown
+func (s *Server) shutdownSessions() {
+	for _, session := range s.listSessions() {
+		if err := session.Shutdown(); err != nil {
+			slog.Error("Error while closing session on shutdown", "error", err, "sessionId", session.Id)
+		}
+	}
+}
+
+// rejectDuplicate answers an upgrade request whose session ID is in use with 409 Conflict
+func rejectDuplicate(w http.ResponseWriter, id string) {
+	slog.Warn("Rejected WebSocket upgrade with duplicate session ID", "sessionId", id)
+	m.DuplicateSessionRejectedCounter.Inc()
+	http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+}
+
+// rejectUpgrade answers an upgrade request that was not admitted by the backend
+// A backend rejection is returned to the client with its status code, upgrade headers and body,
+// failed deliveries with 502 Bad Gateway
+func rejectUpgrade(w http.ResponseWriter, err error) {
+	var statusErr *backend.StatusError
+	if !errors.As(err, &statusErr) {
+		slog.Error("Error while admitting WebSocket connection", "error", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	m.AdmissionRejectedCounter.Inc()
+	for name, values := range backend.UpgradeHeader(statusErr.Header) {
+		w.Header()[name] = values
+	}
+	if contentType := statusErr.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(statusErr.StatusCode)
+	if _, err := w.Write(statusErr.Body); err != nil {
+		slog.Debug("Error while writing rejection body", "error", err)
+	}
+}
+
 func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 	id := uuid.NewString()
-	handler := frontend.NewWsHandler(*slog.Default().With("sessionId", id), id)
 
 	var jwtClaims *string
-	if claims, ok := r.Context().Value(jwt.JwtClaimsKey{}).(map[string]interface{}); ok {
+	claims, _ := r.Context().Value(jwt.JwtClaimsKey{}).(map[string]interface{})
+	if claims != nil {
 		if claimsJSON, err := json.Marshal(claims); err == nil {
 			claimsStr := string(claimsJSON)
 			jwtClaims = &claimsStr
@@ -119,7 +280,22 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	s.addSession(id, session.NewSession(session.SessionParams{
+	claimId, err := s.sessionIdConfig.claimSessionId(claims)
+	if err != nil {
+		slog.Warn("Rejected WebSocket upgrade with invalid session ID claim", "error", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if claimId != "" {
+		if s.sessionIdConfig.duplicatePolicy() == DuplicateReject && s.getSession(claimId) != nil {
+			rejectDuplicate(w, claimId)
+			return
+		}
+		id = claimId
+	}
+
+	handler := frontend.NewWsHandler(*slog.Default().With("sessionId", id), id, s.frontendConfig)
+	sess := session.NewSession(session.SessionParams{
 		Id:           id,
 		Backend:      s.DefaultBackend,
 		ReplyChannel: fmt.Sprintf("%s/%s", s.replyUrl, id),
@@ -127,42 +303,112 @@ func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
 		Connection:   handler,
 		Logger:       *slog.Default().With("sessionId", id),
 		JwtClaims:    jwtClaims,
-	}))
-	defer s.deleteSession(id)
+		ClientIp:     s.clientMetadata.clientIp(r),
+		Headers:      s.clientMetadata.forwardedHeaders(r),
+		Topics:       s.topics,
+		Delivery:     s.delivery,
+		Subprotocols: websocket.Subprotocols(r),
+		Context:      s.context,
+		Expiry:       s.tokenExpiry,
+		ExpiresAt:    session.TokenExpiry(claims),
+		Verifier:     s.refreshVerifier(r),
+	})
 
-	go func() {
-		session := s.getSession(id)
-		if session != nil {
-			session.Receive()
-		} else {
-			slog.Warn("Session ended before starting to receive", "sessionId", id)
+	if handler.CanUpgrade(r) {
+		if handler.BackendAdmission() {
+			if err := sess.Admit(); err != nil {
+				rejectUpgrade(w, err)
+				return
+			}
+		} else if handler.BackendSubprotocol() && len(sess.Subprotocols) > 0 {
+			// the backend selects the subprotocol in its client-connected response
+			if err := sess.Connect(); err != nil {
+				slog.Error("Error while sending client connected message", "error", err)
+			}
 		}
-	}()
 
-	err := handler.Handle(w, r, w.Header())
+		for name, values := range sess.UpgradeHeader() {
+			w.Header()[name] = values
+		}
+		if protocol := sess.SelectedSubprotocol(); protocol != "" {
+			w.Header().Set("Sec-WebSocket-Protocol", protocol)
+		}
+		// clients sending the token as a subprotocol expect one of their subprotocols to be echoed
+		// the marker is used unless a subprotocol is negotiated
+		if marker, ok := r.Context().Value(jwt.SubprotocolMarkerKey{}).(string); ok && w.Header().Get("Sec-WebSocket-Protocol") == "" {
+			w.Header().Set("Sec-WebSocket-Protocol", marker)
+		}
+	}
+
+	// the backend may have assigned the session ID in its client-connected response
+	if sess.Id != id {
+		handler.AssignSessionId(sess.Id)
+	}
+	if err := s.registerSession(sess); err != nil {
+		rejectDuplicate(w, sess.Id)
+		if err := sess.RejectDuplicate(); err != nil {
+			slog.Error("Error while sending client disconnected message", "error", err, "sessionId", sess.Id)
+		}
+		return
+	}
+	defer s.deleteSession(sess)
+
+	go sess.Receive()
+
+	err = handler.Handle(w, r, w.Header())
 	if err != nil {
 		slog.Error("Error while handling WebSocket connection", "error", err)
 	}
 }
 
+// requestVerifier verifies the tokens refreshed by a client against the claim rules with its upgrade request
+type requestVerifier struct {
+	authorizer *jwt.JwtAuthorizer
+	request    *http.Request
+}
+
+func (v requestVerifier) Verify(token string) (map[string]interface{}, error) {
+	return v.authorizer.VerifyFor(token, v.request)
+}
+
+// refreshVerifier returns the verifier of refreshed tokens (nil without JWT authentication)
+func (s *Server) refreshVerifier(r *http.Request) session.TokenVerifier {
+	if s.authorizer == nil {
+		return nil
+	}
+
+	return requestVerifier{authorizer: s.authorizer, request: r}
+}
+
 func (s *Server) getSession(id string) *session.Session {
 	s.sessionsLock.RLock()
 	defer s.sessionsLock.RUnlock()
 	return s.sessions[id]
 }
 
-func (s *Server) deleteSession(id string) {
-	s.sessionsLock.Lock()
-	defer s.sessionsLock.Unlock()
-	delete(s.sessions, id)
-	m.ActiveSessionsGauge.Dec()
+func (s *Server) listSessions() []*session.Session {
+	s.sessionsLock.RLock()
+	defer s.sessionsLock.RUnlock()
+	sessions := make([]*session.Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
 }
 
-func (s *Server) addSession(id string, session *session.Session) {
+// deleteSession removes the session unless its ID was taken over by a new session
+func (s *Server) deleteSession(session *session.Session) {
+	session.Remove()
+
 	s.sessionsLock.Lock()
 	defer s.sessionsLock.Unlock()
-	s.sessions[id] = session
-	m.ActiveSessionsGauge.Inc()
+	if s.sessions[session.Id] != session {
+		return
+	}
+
+	delete(s.sessions, session.Id)
+	s.topics.UnsubscribeAll(session.Id)
+	m.ActiveSessionsGauge.Dec()
 }
 
 func (s *Server) send(w http.ResponseWriter, r *http.Request) {
@@ -170,9 +416,7 @@ func (s *Server) send(w http.ResponseWriter, r *http.Request) {
 	var body []byte
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		slog.Error("Error reading request body", "error", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: "INVALID_REQUEST"})
+		writeBodyError(w, err)
 		return
 	}
 	defer r.Body.Close()
@@ -187,41 +431,105 @@ func (s *Server) send(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts, errCode := parseReplyOptions(r.Header)
+	if errCode != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: errCode})
+		return
+	}
+
 	if len(body) > 0 {
-		err := session.Send(body)
-		if err != nil {
+		err := session.Send(body, opts.messageType)
+		switch {
+		case errors.Is(err, frontend.ErrMessageTooLarge):
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: sendErrorCode(err)})
+			return
+		case errors.Is(err, frontend.ErrInvalidUtf8):
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: sendErrorCode(err)})
+			return
+		case err != nil:
 			slog.Error("Error while sending message", "error", err)
 		}
 	}
 
-	if r.Header.Get(backend.CommandHeader) == backend.TerminateSessionCommand {
-		closeCode, err := backend.GetCloseCode(r.Header.Get(backend.CloseCodeHeader))
+	err = opts.execute(session)
+	if err != nil {
+		slog.Error("Error while executing command", "error", err, "command", opts.command)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(SessionResponse{Success: true})
+	if err != nil {
+		slog.Error("Error while sending response", "error", err)
+	}
+}
+
+// replyOptions holds the message type and session command parsed from reply channel request headers
+type replyOptions struct {
+	messageType backend.MessageType
+	command     string
+	closeCode   int
+	closeReason *string
+	topics      []string
+}
+
+// parseReplyOptions reads the message type and command headers of a reply channel request
+// Returns the parsed options or a SessionResponse error code if the headers are invalid
+func parseReplyOptions(header http.Header) (*replyOptions, string) {
+	messageType, err := backend.ParseMessageType(header.Get(backend.MessageTypeHeader))
+	if err != nil {
+		slog.Error("Error while getting message type", "error", err)
+		return nil, "INVALID_MESSAGE_TYPE"
+	}
+
+	opts := replyOptions{
+		messageType: messageType,
+		command:     header.Get(backend.CommandHeader),
+	}
+
+	switch opts.command {
+	case backend.TerminateSessionCommand:
+		closeCode, err := backend.GetCloseCode(header.Get(backend.CloseCodeHeader))
 		if err != nil {
 			slog.Error("Error while getting close code", "error", err)
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: "INVALID_CLOSE_CODE"})
-			return
+			return nil, "INVALID_CLOSE_CODE"
 		}
 
-		closeReason, err := backend.GetCloseReason(r.Header.Get(backend.CloseReasonHeader))
+		closeReason, err := backend.GetCloseReason(header.Get(backend.CloseReasonHeader))
 		if err != nil {
 			slog.Error("Error while getting close reason", "error", err)
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: "INVALID_CLOSE_REASON"})
-			return
+			return nil, "INVALID_CLOSE_REASON"
 		}
 
-		err = session.Close(closeCode, closeReason)
-
+		opts.closeCode = closeCode
+		opts.closeReason = closeReason
+	case backend.SubscribeCommand, backend.UnsubscribeCommand:
+		topics, err := backend.ParseTopics(header.Get(backend.TopicsHeader))
 		if err != nil {
-			slog.Error("Error while closing session", "error", err)
+			slog.Error("Error while getting topics", "error", err)
+			return nil, "INVALID_TOPICS"
 		}
+
+		opts.topics = topics
 	}
 
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(SessionResponse{Success: true})
-	if err != nil {
-		slog.Error("Error while sending response", "error", err)
+	return &opts, ""
+}
+
+// execute runs the command on the session
+// Returns an error if the command fails
+func (o *replyOptions) execute(session *session.Session) error {
+	switch o.command {
+	case backend.TerminateSessionCommand:
+		return session.Close(o.closeCode, o.closeReason)
+	case backend.SubscribeCommand:
+		return session.Subscribe(o.topics)
+	case backend.UnsubscribeCommand:
+		return session.Unsubscribe(o.topics)
+	default:
+		return nil
 	}
 }
 
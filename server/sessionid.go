@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+	"github.com/ws2wh/ws2wh/session"
+)
+
+// DuplicatePolicy selects what happens when an assigned session ID is already in use
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject rejects the new connection with 409 Conflict
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateReplace closes the existing session and hands its ID to the new connection
+	DuplicateReplace DuplicatePolicy = "replace"
+)
+
+// errDuplicateSession is returned when a session ID is in use and the duplicate policy rejects the connection
+var errDuplicateSession = errors.New("session ID is already in use")
+
+// Validate checks the session ID configuration
+// Returns an error if the duplicate policy is unknown
+func (c *SessionIdConfig) Validate() error {
+	switch c.DuplicatePolicy {
+	case "", DuplicateReject, DuplicateReplace:
+		return nil
+	default:
+		return fmt.Errorf("unknown duplicate session policy: %s", c.DuplicatePolicy)
+	}
+}
+
+func (c *SessionIdConfig) duplicatePolicy() DuplicatePolicy {
+	if c == nil || c.DuplicatePolicy == "" {
+		return DuplicateReject
+	}
+
+	return c.DuplicatePolicy
+}
+
+// claimSessionId returns the session ID taken from the configured JWT claim
+// Returns an empty string if no claim is configured or the token does not contain it,
+// and an error if the claim is not a valid session ID
+func (c *SessionIdConfig) claimSessionId(claims map[string]interface{}) (string, error) {
+	if c == nil || c.JwtClaim == "" || claims == nil {
+		return "", nil
+	}
+
+	value, ok := claims[c.JwtClaim]
+	if !ok {
+		return "", nil
+	}
+
+	id, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("JWT claim %s must be a string to be used as session ID", c.JwtClaim)
+	}
+
+	if err := session.ValidateId(id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// registerSession adds the session under its ID, applying the duplicate policy if the ID is in use
+// A replaced session is closed and its topic subscriptions are taken over by the new session
+// Returns errDuplicateSession if the policy rejects the session
+func (s *Server) registerSession(sess *session.Session) error {
+	s.sessionsLock.Lock()
+	existing := s.sessions[sess.Id]
+	if existing != nil && s.sessionIdConfig.duplicatePolicy() == DuplicateReject {
+		s.sessionsLock.Unlock()
+		return errDuplicateSession
+	}
+
+	s.sessions[sess.Id] = sess
+	s.sessionsLock.Unlock()
+
+	if existing == nil {
+		m.ActiveSessionsGauge.Inc()
+		return nil
+	}
+
+	m.SessionReplacedCounter.Inc()
+	if err := existing.Replace(); err != nil {
+		slog.Error("Error while closing replaced session", "error", err, "sessionId", sess.Id)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// topicRegistry keeps track of session subscriptions to named topics
+type topicRegistry struct {
+	// subscribers maps topic names to subscribed session IDs
+	subscribers map[string]map[string]struct{}
+	// subscriptions maps session IDs to subscribed topic names
+	subscriptions map[string]map[string]struct{}
+	lock          sync.RWMutex
+}
+
+func newTopicRegistry() *topicRegistry {
+	return &topicRegistry{
+		subscribers:   make(map[string]map[string]struct{}),
+		subscriptions: make(map[string]map[string]struct{}),
+	}
+}
+
+// Subscribe adds the session to the given topics
+// Nothing is subscribed once removed reports the session as removed, the check runs under the registry lock
+// so a subscription cannot outlive the UnsubscribeAll of a removed session
+func (t *topicRegistry) Subscribe(sessionId string, removed func() bool, topics ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if removed() {
+		return
+	}
+
+	for _, topic := range topics {
+		if t.subscribers[topic] == nil {
+			t.subscribers[topic] = make(map[string]struct{})
+		}
+		if t.subscriptions[sessionId] == nil {
+			t.subscriptions[sessionId] = make(map[string]struct{})
+		}
+
+		t.subscribers[topic][sessionId] = struct{}{}
+		t.subscriptions[sessionId][topic] = struct{}{}
+		m.TopicSubscribersGauge.WithLabelValues(topic).Set(float64(len(t.subscribers[topic])))
+	}
+}
+
+// Unsubscribe removes the session from the given topics
+func (t *topicRegistry) Unsubscribe(sessionId string, topics ...string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.unsubscribe(sessionId, topics...)
+}
+
+// UnsubscribeAll removes the session from all of its topics
+func (t *topicRegistry) UnsubscribeAll(sessionId string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	topics := make([]string, 0, len(t.subscriptions[sessionId]))
+	for topic := range t.subscriptions[sessionId] {
+		topics = append(topics, topic)
+	}
+	t.unsubscribe(sessionId, topics...)
+}
+
+// Subscribers returns the IDs of sessions subscribed to the topic
+func (t *topicRegistry) Subscribers(topic string) []string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	ids := make([]string, 0, len(t.subscribers[topic]))
+	for id := range t.subscribers[topic] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (t *topicRegistry) unsubscribe(sessionId string, topics ...string) {
+	for _, topic := range topics {
+		delete(t.subscribers[topic], sessionId)
+		delete(t.subscriptions[sessionId], topic)
+
+		if len(t.subscribers[topic]) == 0 {
+			delete(t.subscribers, topic)
+			m.TopicSubscribersGauge.DeleteLabelValues(topic)
+		} else {
+			m.TopicSubscribersGauge.WithLabelValues(topic).Set(float64(len(t.subscribers[topic])))
+		}
+	}
+
+	if len(t.subscriptions[sessionId]) == 0 {
+		delete(t.subscriptions, sessionId)
+	}
+}
+
+// publish delivers the request body to all sessions subscribed to the topic
+func (s *Server) publish(w http.ResponseWriter, r *http.Request) {
+	topic := mux.Vars(r)["name"]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	defer r.Body.Close()
+
+	opts, errCode := parseReplyOptions(r.Header)
+	if errCode != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(SessionResponse{Success: false, Message: errCode})
+		return
+	}
+
+	writeDeliveryResults(w, s.deliverAll(s.topics.Subscribers(topic), body, opts))
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/ws2wh/ws2wh/backend"
+)
+
+// ContextField is a session context field sent to the backend with events
+type ContextField string
+
+const (
+	// ContextClaims sends the JWT claims of the client
+	ContextClaims ContextField = "claims"
+	// ContextQuery sends the query string of the upgrade request
+	ContextQuery ContextField = "query"
+	// ContextHeaders sends the forwarded upgrade request headers
+	ContextHeaders ContextField = "headers"
+	// ContextConnectedAt sends the time the client connected
+	ContextConnectedAt ContextField = "connected-at"
+)
+
+// AllContextFields lists every session context field
+var AllContextFields = []ContextField{ContextClaims, ContextQuery, ContextHeaders, ContextConnectedAt}
+
+// ContextConfig selects the session context fields sent with each event
+// A nil list sends all fields, an empty list sends none
+type ContextConfig struct {
+	// Connected lists the fields sent with client-connected events (default: all)
+	Connected []ContextField
+	// Message lists the fields sent with message-received events (default: all)
+	Message []ContextField
+	// Disconnected lists the fields sent with client-disconnected events (default: all)
+	Disconnected []ContextField
+}
+
+// Validate checks the session context configuration
+// Returns an error if a field is unknown
+func (c *ContextConfig) Validate() error {
+	for _, fields := range [][]ContextField{c.Connected, c.Message, c.Disconnected} {
+		for _, field := range fields {
+			switch field {
+			case ContextClaims, ContextQuery, ContextHeaders, ContextConnectedAt:
+			default:
+				return fmt.Errorf("unknown session context field: %s", field)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fields returns the context fields sent with the given event
+func (c *ContextConfig) fields(event backend.WsEvent) []ContextField {
+	if c == nil {
+		return AllContextFields
+	}
+
+	var fields []ContextField
+	switch event {
+	case backend.ClientConnected:
+		fields = c.Connected
+	case backend.MessageReceived:
+		fields = c.Message
+	case backend.ClientDisconnected:
+		fields = c.Disconnected
+	}
+
+	if fields == nil {
+		return AllContextFields
+	}
+
+	return fields
+}
+
+// newMessage creates a backend message for the event carrying the configured session context
+func (s *Session) newMessage(event backend.WsEvent) backend.BackendMessage {
+	msg := backend.BackendMessage{
+		SessionId:    s.Id,
+		ReplyChannel: s.ReplyChannel,
+		Event:        event,
+		Payload:      make([]byte, 0),
+		ClientIp:     s.ClientIp,
+		Subprotocol:  s.Connection.Subprotocol(),
+	}
+
+	for _, field := range s.Context.fields(event) {
+		switch field {
+		case ContextClaims:
+			msg.JwtClaims = s.JwtClaims
+		case ContextQuery:
+			msg.QueryString = s.QueryString
+		case ContextHeaders:
+			msg.Headers = s.Headers
+		case ContextConnectedAt:
+			msg.ConnectedAt = s.ConnectedAt
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+)
+
+func TestSession_ReceiveContext(t *testing.T) {
+	claims := `{"sub":"user"}`
+	connectedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	conn := NewMockWebsocketConn()
+	mockBackend := &MockBackend{}
+	session := &Session{
+		Id:          "test-session",
+		Backend:     mockBackend,
+		Connection:  conn,
+		Logger:      *slog.Default(),
+		QueryString: "room=1",
+		JwtClaims:   &claims,
+		Headers:     http.Header{"User-Agent": {"test-agent"}},
+		ConnectedAt: connectedAt,
+		Context: &ContextConfig{
+			Message:      []ContextField{ContextClaims},
+			Disconnected: []ContextField{},
+		},
+	}
+
+	conn.receiverChan <- Message{Type: backend.TextMessage, Payload: []byte("test message")}
+	close(conn.receiverChan)
+	go func() {
+		conn.doneChan <- ConnectionReadySignal
+	}()
+
+	session.Receive()
+
+	if !assert.Len(t, mockBackend.messages, 3, "Should have 3 backend messages") {
+		return
+	}
+
+	connected := mockBackend.messages[0]
+	assert.Equal(t, &claims, connected.JwtClaims, "Connected event should carry all fields by default")
+	assert.Equal(t, "room=1", connected.QueryString)
+	assert.Equal(t, "test-agent", connected.Headers.Get("User-Agent"))
+	assert.Equal(t, connectedAt, connected.ConnectedAt)
+
+	received := mockBackend.messages[1]
+	assert.Equal(t, &claims, received.JwtClaims, "Message event should carry the claims")
+	assert.Empty(t, received.QueryString, "Message event should only carry the configured fields")
+	assert.Nil(t, received.Headers)
+	assert.True(t, received.ConnectedAt.IsZero())
+
+	disconnected := mockBackend.messages[2]
+	assert.Nil(t, disconnected.JwtClaims, "Disconnected event should carry no context fields")
+	assert.Empty(t, disconnected.QueryString)
+	assert.Equal(t, "test-session", disconnected.SessionId)
+}
+
+func TestSession_ReceiveSetsConnectedAt(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	mockBackend := &MockBackend{}
+	session := &Session{Id: "test-session", Backend: mockBackend, Connection: conn, Logger: *slog.Default()}
+
+	close(conn.receiverChan)
+	go func() {
+		conn.doneChan <- ConnectionReadySignal
+	}()
+
+	before := time.Now()
+	session.Receive()
+
+	if assert.Len(t, mockBackend.messages, 2, "Should have 2 backend messages") {
+		assert.False(t, mockBackend.messages[0].ConnectedAt.Before(before.Truncate(time.Second)), "Connected at should be set on connect")
+		assert.Equal(t, mockBackend.messages[0].ConnectedAt, mockBackend.messages[1].ConnectedAt)
+	}
+}
+
+func TestContextConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&ContextConfig{}).Validate())
+	assert.NoError(t, (&ContextConfig{Message: AllContextFields, Disconnected: []ContextField{}}).Validate())
+	assert.Error(t, (&ContextConfig{Connected: []ContextField{"unknown"}}).Validate())
+}
@@ -0,0 +1,205 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/backend"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+// DeliveryMode selects how client messages are delivered to the backend
+type DeliveryMode string
+
+const (
+	// DeliveryOrdered delivers the messages of a session one at a time, preserving their order
+	DeliveryOrdered DeliveryMode = "ordered"
+	// DeliveryConcurrent delivers up to MaxInFlight messages of a session at the same time
+	DeliveryConcurrent DeliveryMode = "concurrent"
+)
+
+// BackpressurePolicy selects what happens when the delivery queue of a session is full
+type BackpressurePolicy string
+
+const (
+	// BackpressureBlock stops reading from the client until there is room in the queue
+	BackpressureBlock BackpressurePolicy = "block"
+	// BackpressureDropOldest discards the oldest queued message to make room for the new one
+	BackpressureDropOldest BackpressurePolicy = "drop-oldest"
+	// BackpressureDisconnect closes the client connection
+	BackpressureDisconnect BackpressurePolicy = "disconnect"
+)
+
+const (
+	defaultQueueSize   = 64
+	defaultMaxInFlight = 4
+
+	// queueFullCloseCode is the close code sent to clients disconnected by BackpressureDisconnect (Try Again Later)
+	queueFullCloseCode = 1013
+)
+
+const queueFullCloseReason = "Message queue full"
+
+// DeliveryConfig holds the parameters of client to backend message delivery
+type DeliveryConfig struct {
+	// Mode selects ordered or concurrent delivery (default: ordered)
+	Mode DeliveryMode
+	// QueueSize is the maximum number of messages waiting for delivery per session (default: 64)
+	QueueSize int
+	// MaxInFlight is the maximum number of concurrent backend requests per session (concurrent mode; default: 4)
+	MaxInFlight int
+	// Backpressure selects what happens when the queue is full (block, drop-oldest, disconnect; default: block)
+	Backpressure BackpressurePolicy
+}
+
+// Validate checks the delivery configuration
+// Returns an error if the mode or backpressure policy is unknown or a limit is negative
+func (c *DeliveryConfig) Validate() error {
+	switch c.Mode {
+	case "", DeliveryOrdered, DeliveryConcurrent:
+	default:
+		return fmt.Errorf("unknown delivery mode: %s", c.Mode)
+	}
+
+	switch c.Backpressure {
+	case "", BackpressureBlock, BackpressureDropOldest, BackpressureDisconnect:
+	default:
+		return fmt.Errorf("unknown backpressure policy: %s", c.Backpressure)
+	}
+
+	if c.QueueSize < 0 {
+		return fmt.Errorf("delivery queue size must not be negative")
+	}
+
+	if c.MaxInFlight < 0 {
+		return fmt.Errorf("delivery max in-flight must not be negative")
+	}
+
+	return nil
+}
+
+// withDefaults returns a copy of the configuration with unset values replaced by defaults
+func (c *DeliveryConfig) withDefaults() DeliveryConfig {
+	config := DeliveryConfig{}
+	if c != nil {
+		config = *c
+	}
+
+	if config.Mode == "" {
+		config.Mode = DeliveryOrdered
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Mode == DeliveryOrdered {
+		config.MaxInFlight = 1
+	} else if config.MaxInFlight <= 0 {
+		config.MaxInFlight = defaultMaxInFlight
+	}
+	if config.Backpressure == "" {
+		config.Backpressure = BackpressureBlock
+	}
+
+	return config
+}
+
+// deliveryQueue buffers client messages of a session and delivers them
+// to the backend using a pool of MaxInFlight workers
+type deliveryQueue struct {
+	session  *Session
+	config   DeliveryConfig
+	messages chan backend.BackendMessage
+	workers  sync.WaitGroup
+	closing  bool
+}
+
+// startDelivery creates the delivery queue of the session and starts its workers
+func (s *Session) startDelivery() *deliveryQueue {
+	config := s.Delivery.withDefaults()
+	q := &deliveryQueue{
+		session:  s,
+		config:   config,
+		messages: make(chan backend.BackendMessage, config.QueueSize),
+	}
+
+	for i := 0; i < config.MaxInFlight; i++ {
+		q.workers.Add(1)
+		go q.work()
+	}
+
+	return q
+}
+
+// push adds the message to the queue applying the backpressure policy if the queue is full
+func (q *deliveryQueue) push(msg backend.BackendMessage) {
+	if q.closing {
+		q.session.Logger.Debug("Discarding message, session is closing")
+		return
+	}
+
+	select {
+	case q.messages <- msg:
+		m.DeliveryQueueGauge.Inc()
+		return
+	default:
+	}
+
+	m.BackpressureCounter.With(prometheus.Labels{
+		m.PolicyLabel: string(q.config.Backpressure),
+	}).Inc()
+
+	switch q.config.Backpressure {
+	case BackpressureDropOldest:
+		for {
+			select {
+			case dropped := <-q.messages:
+				m.DeliveryQueueGauge.Dec()
+				q.session.Logger.Warn("Delivery queue full, dropping oldest message", "payloadSize", len(dropped.Payload))
+			default:
+			}
+
+			select {
+			case q.messages <- msg:
+				m.DeliveryQueueGauge.Inc()
+				return
+			default:
+			}
+		}
+	case BackpressureDisconnect:
+		q.session.Logger.Warn("Delivery queue full, disconnecting client")
+		q.closing = true
+		err := q.session.Connection.CloseWithInfo(CloseInfo{
+			Code:   queueFullCloseCode,
+			Reason: queueFullCloseReason,
+			Origin: backend.CloseOriginServer,
+		})
+		if err != nil {
+			q.session.Logger.Error("Error while closing connection", "error", err)
+		}
+	default:
+		q.session.Logger.Debug("Delivery queue full, waiting for backend")
+		q.messages <- msg
+		m.DeliveryQueueGauge.Inc()
+	}
+}
+
+// stop closes the queue and waits until all queued messages are delivered
+func (q *deliveryQueue) stop() {
+	close(q.messages)
+	q.workers.Wait()
+}
+
+func (q *deliveryQueue) work() {
+	defer q.workers.Done()
+
+	for msg := range q.messages {
+		m.DeliveryQueueGauge.Dec()
+		m.DeliveryInFlightGauge.Inc()
+		err := q.session.Backend.Send(msg, q.session)
+		m.DeliveryInFlightGauge.Dec()
+		if err != nil {
+			q.session.Logger.Error("Error while sending message received message", "error", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package session
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+)
+
+// BlockingBackend implements backend.Backend, holding every delivery until released
+type BlockingBackend struct {
+	lock     sync.Mutex
+	payloads []string
+	release  chan struct{}
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func NewBlockingBackend() *BlockingBackend {
+	return &BlockingBackend{release: make(chan struct{})}
+}
+
+func (b *BlockingBackend) Send(msg backend.BackendMessage, s backend.SessionHandle) error {
+	current := b.inFlight.Add(1)
+	for {
+		seen := b.maxSeen.Load()
+		if current <= seen || b.maxSeen.CompareAndSwap(seen, current) {
+			break
+		}
+	}
+
+	<-b.release
+	b.inFlight.Add(-1)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.payloads = append(b.payloads, string(msg.Payload))
+	return nil
+}
+
+func (b *BlockingBackend) delivered() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.payloads...)
+}
+
+func newDeliverySession(b backend.Backend, conn *MockWebsocketConn, config *DeliveryConfig) *Session {
+	return &Session{
+		Id:         "test-session",
+		Backend:    b,
+		Connection: conn,
+		Logger:     *slog.Default(),
+		Delivery:   config,
+	}
+}
+
+func TestDeliveryConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&DeliveryConfig{}).Validate())
+	assert.NoError(t, (&DeliveryConfig{Mode: DeliveryConcurrent, Backpressure: BackpressureDropOldest}).Validate())
+	assert.Error(t, (&DeliveryConfig{Mode: "parallel"}).Validate())
+	assert.Error(t, (&DeliveryConfig{Backpressure: "drop-newest"}).Validate())
+	assert.Error(t, (&DeliveryConfig{QueueSize: -1}).Validate())
+}
+
+func TestDelivery_Ordered(t *testing.T) {
+	b := NewBlockingBackend()
+	close(b.release)
+	s := newDeliverySession(b, NewMockWebsocketConn(), nil)
+
+	queue := s.startDelivery()
+	for _, payload := range []string{"1", "2", "3", "4", "5"} {
+		queue.push(backend.BackendMessage{Payload: []byte(payload)})
+	}
+	queue.stop()
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, b.delivered())
+	assert.Equal(t, int32(1), b.maxSeen.Load(), "ordered mode should deliver one message at a time")
+}
+
+func TestDelivery_Concurrent(t *testing.T) {
+	b := NewBlockingBackend()
+	s := newDeliverySession(b, NewMockWebsocketConn(), &DeliveryConfig{
+		Mode:        DeliveryConcurrent,
+		MaxInFlight: 3,
+	})
+
+	queue := s.startDelivery()
+	for i := 0; i < 6; i++ {
+		queue.push(backend.BackendMessage{Payload: []byte("msg")})
+	}
+
+	assert.Eventually(t, func() bool { return b.inFlight.Load() == 3 }, time.Second, time.Millisecond*10)
+	close(b.release)
+	queue.stop()
+
+	assert.Len(t, b.delivered(), 6)
+	assert.Equal(t, int32(3), b.maxSeen.Load(), "in-flight requests should be limited")
+}
+
+func TestDelivery_DropOldest(t *testing.T) {
+	b := NewBlockingBackend()
+	s := newDeliverySession(b, NewMockWebsocketConn(), &DeliveryConfig{
+		QueueSize:    2,
+		Backpressure: BackpressureDropOldest,
+	})
+
+	queue := s.startDelivery()
+	queue.push(backend.BackendMessage{Payload: []byte("in-flight")})
+	assert.Eventually(t, func() bool { return b.inFlight.Load() == 1 }, time.Second, time.Millisecond*10)
+
+	for _, payload := range []string{"1", "2", "3", "4"} {
+		queue.push(backend.BackendMessage{Payload: []byte(payload)})
+	}
+	close(b.release)
+	queue.stop()
+
+	assert.Equal(t, []string{"in-flight", "3", "4"}, b.delivered())
+}
+
+func TestDelivery_Disconnect(t *testing.T) {
+	b := NewBlockingBackend()
+	conn := NewMockWebsocketConn()
+	s := newDeliverySession(b, conn, &DeliveryConfig{
+		QueueSize:    1,
+		Backpressure: BackpressureDisconnect,
+	})
+
+	queue := s.startDelivery()
+	queue.push(backend.BackendMessage{Payload: []byte("in-flight")})
+	assert.Eventually(t, func() bool { return b.inFlight.Load() == 1 }, time.Second, time.Millisecond*10)
+
+	queue.push(backend.BackendMessage{Payload: []byte("queued")})
+	queue.push(backend.BackendMessage{Payload: []byte("overflow")})
+	queue.push(backend.BackendMessage{Payload: []byte("discarded")})
+	close(b.release)
+	queue.stop()
+
+	assert.True(t, conn.closeCalled, "client should be disconnected")
+	assert.Equal(t, queueFullCloseCode, conn.lastCloseCode)
+	assert.Equal(t, backend.CloseOriginServer, conn.closeInfo.Origin)
+	assert.Equal(t, []string{"in-flight", "queued"}, b.delivered())
+}
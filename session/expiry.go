@@ -0,0 +1,256 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/http-middleware/jwt"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+)
+
+const (
+	// DefaultExpiryCloseCode is the close code sent to clients whose token expired
+	DefaultExpiryCloseCode = 4001
+	// DefaultExpiryCloseReason is the close reason sent to clients whose token expired
+	DefaultExpiryCloseReason = "Token expired"
+	// DefaultExpiryWarningMessage is the message sent to clients before their token expires
+	DefaultExpiryWarningMessage = `{"type":"token-expiring","exp":{exp}}`
+	// DefaultRefreshType is the type of the messages carrying a new token
+	DefaultRefreshType = "token-refresh"
+)
+
+// TokenExpiryConfig ties the session lifetime to the expiry (exp) of the client's JWT
+// Clients extend their session by sending a new token in a text message: {"type":"token-refresh","token":"<jwt>"}
+type TokenExpiryConfig struct {
+	// WarningBefore is how long before the expiry the warning message is sent (0 disables the warning)
+	WarningBefore time.Duration
+	// WarningMessage is the text message sent before the expiry, {exp} is replaced with the expiry
+	// in seconds since the epoch (default: {"type":"token-expiring","exp":{exp}})
+	WarningMessage string
+	// CloseCode is the close code of sessions whose token expired (default: 4001)
+	CloseCode int
+	// CloseReason is the close reason of sessions whose token expired (default: Token expired)
+	CloseReason string
+	// RefreshType is the type of the messages carrying a new token (default: token-refresh)
+	RefreshType string
+}
+
+// Validate checks the token expiry configuration
+// Returns an error if the warning time is negative or the close code cannot be sent by a server
+func (c *TokenExpiryConfig) Validate() error {
+	if c.WarningBefore < 0 {
+		return fmt.Errorf("token expiry warning time must not be negative")
+	}
+
+	switch {
+	case c.CloseCode == 0, c.CloseCode == 1000, c.CloseCode == 1001, c.CloseCode == 1008:
+	case c.CloseCode >= 3000 && c.CloseCode <= 4999:
+	default:
+		return fmt.Errorf("invalid token expiry close code: %d", c.CloseCode)
+	}
+
+	return nil
+}
+
+func (c *TokenExpiryConfig) closeInfo() CloseInfo {
+	info := CloseInfo{Code: c.CloseCode, Reason: c.CloseReason, Origin: backend.CloseOriginTokenExpired}
+	if info.Code == 0 {
+		info.Code = DefaultExpiryCloseCode
+	}
+	if info.Reason == "" {
+		info.Reason = DefaultExpiryCloseReason
+	}
+	return info
+}
+
+func (c *TokenExpiryConfig) warningMessage(expiresAt time.Time) []byte {
+	message := c.WarningMessage
+	if message == "" {
+		message = DefaultExpiryWarningMessage
+	}
+	return []byte(strings.ReplaceAll(message, "{exp}", strconv.FormatInt(expiresAt.Unix(), 10)))
+}
+
+func (c *TokenExpiryConfig) refreshType() string {
+	if c.RefreshType == "" {
+		return DefaultRefreshType
+	}
+	return c.RefreshType
+}
+
+// TokenVerifier verifies the tokens sent by clients to extend their session
+type TokenVerifier interface {
+	// Verify returns the claims of a valid token
+	Verify(token string) (map[string]interface{}, error)
+}
+
+// expiryTimer fires at the warning and at the expiry of the session token
+type expiryTimer struct {
+	timer *time.Timer
+	// warned is set once the warning was sent for the current token
+	warned bool
+}
+
+// C returns the channel of the timer, nil if the session does not expire
+func (e *expiryTimer) C() <-chan time.Time {
+	if e == nil {
+		return nil
+	}
+	return e.timer.C
+}
+
+func (e *expiryTimer) stop() {
+	if e != nil {
+		e.timer.Stop()
+	}
+}
+
+// startExpiry starts the expiry timer of the session
+// Returns nil if the session lifetime is not tied to its token
+func (s *Session) startExpiry() *expiryTimer {
+	if s.Expiry == nil {
+		return nil
+	}
+
+	e := &expiryTimer{timer: time.NewTimer(0)}
+	s.scheduleExpiry(e)
+	return e
+}
+
+// scheduleExpiry sets the timer to the next warning or expiry
+// The timer is stopped if the token does not expire
+func (s *Session) scheduleExpiry(e *expiryTimer) {
+	if s.ExpiresAt.IsZero() {
+		e.timer.Stop()
+		return
+	}
+
+	at := s.ExpiresAt
+	if !e.warned && s.Expiry.WarningBefore > 0 {
+		at = at.Add(-s.Expiry.WarningBefore)
+	}
+	e.timer.Reset(time.Until(at))
+}
+
+// onExpiryTimer sends the expiry warning or closes the session whose token expired
+func (s *Session) onExpiryTimer(e *expiryTimer) {
+	if !e.warned && s.Expiry.WarningBefore > 0 && time.Now().Before(s.ExpiresAt) {
+		e.warned = true
+		s.Logger.Debug("Sending token expiry warning", "expiresAt", s.ExpiresAt)
+		if err := s.Send(s.Expiry.warningMessage(s.ExpiresAt), backend.TextMessage); err != nil {
+			s.Logger.Warn("Error while sending token expiry warning", "error", err)
+		}
+		s.scheduleExpiry(e)
+		return
+	}
+
+	s.Logger.Info("Closing session with expired token", "expiresAt", s.ExpiresAt)
+	m.SessionExpiredCounter.Inc()
+	if err := s.Connection.CloseWithInfo(s.Expiry.closeInfo()); err != nil {
+		s.Logger.Debug("Error while closing session with expired token", "error", err)
+	}
+}
+
+// tokenRefresh returns the token of a refresh message
+// Returns false if the message is not a refresh message
+func (s *Session) tokenRefresh(msg Message) (string, bool) {
+	if s.Expiry == nil || s.Verifier == nil || msg.Type != backend.TextMessage || !bytes.HasPrefix(bytes.TrimSpace(msg.Payload), []byte("{")) {
+		return "", false
+	}
+
+	refresh := struct {
+		Type  string `json:"type"`
+		Token string `json:"token"`
+	}{}
+	if err := json.Unmarshal(msg.Payload, &refresh); err != nil || refresh.Type != s.Expiry.refreshType() {
+		return "", false
+	}
+
+	return refresh.Token, true
+}
+
+// refreshToken replaces the session token with a new token of the same subject
+// The session expires with the new token; rejected tokens leave the session unchanged
+func (s *Session) refreshToken(token string, e *expiryTimer) {
+	claims, err := s.Verifier.Verify(token)
+	if err != nil {
+		s.Logger.Warn("Rejected token refresh", "error", err)
+		countRefresh(m.ResultValueRejected)
+		return
+	}
+
+	// without a subject, the tokens cannot be proven to belong to the same principal
+	subject := claimString(claims, "sub")
+	if subject == "" {
+		s.Logger.Warn("Rejected token refresh without a subject")
+		countRefresh(m.ResultValueRejected)
+		return
+	}
+
+	if subject != s.tokenSubject() {
+		s.Logger.Warn("Rejected token refresh with a different subject", "subject", subject)
+		countRefresh(m.ResultValueRejected)
+		return
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		s.Logger.Error("Failed to marshal JWT claims", "error", err)
+		countRefresh(m.ResultValueRejected)
+		return
+	}
+
+	claimsStr := string(claimsJSON)
+	s.JwtClaims = &claimsStr
+	s.ExpiresAt = TokenExpiry(claims)
+	s.Logger.Info("Refreshed session token", "expiresAt", s.ExpiresAt)
+	countRefresh(m.ResultValueAccepted)
+
+	e.warned = false
+	s.scheduleExpiry(e)
+}
+
+// tokenSubject returns the sub claim of the session token (empty if none)
+func (s *Session) tokenSubject() string {
+	if s.JwtClaims == nil {
+		return ""
+	}
+
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(*s.JwtClaims), &claims); err != nil {
+		return ""
+	}
+	return claimString(claims, "sub")
+}
+
+// TokenExpiry returns the expiry (exp) of the token with the given claims
+// Returns the zero time if the token does not expire or its exp claim is not a valid NumericDate
+func TokenExpiry(claims map[string]interface{}) time.Time {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	expiresAt, err := jwt.ParseNumericDate(exp)
+	if err != nil {
+		return time.Time{}
+	}
+	return expiresAt
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func countRefresh(result string) {
+	m.TokenRefreshCounter.With(prometheus.Labels{
+		m.ResultLabel: result,
+	}).Inc()
+}
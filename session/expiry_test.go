@@ -0,0 +1,138 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+)
+
+// mockVerifier returns the claims registered for a token
+type mockVerifier map[string]map[string]interface{}
+
+func (v mockVerifier) Verify(token string) (map[string]interface{}, error) {
+	claims, ok := v[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func TestSession_Expiry(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	expiresAt := time.Now().Add(30 * time.Second)
+	session := &Session{
+		Connection: conn,
+		Logger:     *slog.Default(),
+		Expiry:     &TokenExpiryConfig{WarningBefore: time.Minute},
+		ExpiresAt:  expiresAt,
+	}
+
+	expiry := session.startExpiry()
+	defer expiry.stop()
+	select {
+	case <-expiry.C():
+	case <-time.After(time.Second):
+		assert.Fail(t, "warning should be due")
+		return
+	}
+
+	session.onExpiryTimer(expiry)
+	assert.Equal(t, backend.TextMessage, conn.lastMessageType)
+	assert.Equal(t, fmt.Sprintf(`{"type":"token-expiring","exp":%d}`, expiresAt.Unix()), string(conn.lastPayload))
+	assert.False(t, conn.closeCalled, "session should stay open until the expiry")
+
+	session.onExpiryTimer(expiry)
+	assert.Equal(t, &CloseInfo{
+		Code:   DefaultExpiryCloseCode,
+		Reason: DefaultExpiryCloseReason,
+		Origin: backend.CloseOriginTokenExpired,
+	}, conn.closeInfo)
+}
+
+func TestSession_NoExpiry(t *testing.T) {
+	assert.Nil(t, (&Session{}).startExpiry(), "sessions without expiry config should not expire")
+
+	session := &Session{Logger: *slog.Default(), Expiry: &TokenExpiryConfig{}}
+	expiry := session.startExpiry()
+	defer expiry.stop()
+	select {
+	case <-expiry.C():
+		assert.Fail(t, "tokens without exp should not expire")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSession_RefreshToken(t *testing.T) {
+	claims := `{"sub":"user-42"}`
+	newExpiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	session := &Session{
+		Connection: NewMockWebsocketConn(),
+		Logger:     *slog.Default(),
+		JwtClaims:  &claims,
+		Expiry:     &TokenExpiryConfig{},
+		ExpiresAt:  time.Now().Add(time.Minute),
+		Verifier: mockVerifier{
+			"renewed": {"sub": "user-42", "exp": float64(newExpiry.Unix())},
+			"other":   {"sub": "user-43", "exp": float64(newExpiry.Unix())},
+		},
+	}
+	expiry := session.startExpiry()
+	defer expiry.stop()
+	expiry.warned = true
+
+	_, ok := session.tokenRefresh(Message{Type: backend.TextMessage, Payload: []byte(`{"type":"chat","token":"renewed"}`)})
+	assert.False(t, ok, "other message types should be forwarded")
+	_, ok = session.tokenRefresh(Message{Type: backend.BinaryMessage, Payload: []byte(`{"type":"token-refresh","token":"renewed"}`)})
+	assert.False(t, ok, "binary messages should be forwarded")
+
+	for _, token := range []string{"invalid", "other"} {
+		refreshed, ok := session.tokenRefresh(Message{Type: backend.TextMessage, Payload: []byte(`{"type":"token-refresh","token":"` + token + `"}`)})
+		if assert.True(t, ok) {
+			session.refreshToken(refreshed, expiry)
+		}
+		assert.Equal(t, claims, *session.JwtClaims, "rejected tokens should not change the session")
+	}
+
+	session.refreshToken("renewed", expiry)
+	assert.True(t, newExpiry.Equal(session.ExpiresAt))
+	assert.JSONEq(t, fmt.Sprintf(`{"sub":"user-42","exp":%d}`, newExpiry.Unix()), *session.JwtClaims)
+	assert.False(t, expiry.warned, "a new warning should be sent before the new expiry")
+}
+
+func TestSession_RefreshTokenWithoutSubject(t *testing.T) {
+	claims := `{"exp":1767225600}`
+	session := &Session{
+		Connection: NewMockWebsocketConn(),
+		Logger:     *slog.Default(),
+		JwtClaims:  &claims,
+		Expiry:     &TokenExpiryConfig{},
+		ExpiresAt:  time.Now().Add(time.Minute),
+		Verifier: mockVerifier{
+			"renewed": {"exp": float64(time.Now().Add(time.Hour).Unix())},
+		},
+	}
+	expiry := session.startExpiry()
+	defer expiry.stop()
+
+	session.refreshToken("renewed", expiry)
+	assert.Equal(t, claims, *session.JwtClaims, "tokens without sub should not extend the session")
+}
+
+func TestTokenExpiry(t *testing.T) {
+	assert.True(t, TokenExpiry(map[string]interface{}{}).IsZero(), "tokens without exp should not expire")
+	assert.Equal(t, int64(9999999999), TokenExpiry(map[string]interface{}{"exp": float64(9999999999)}).Unix(),
+		"far future expiries should not overflow")
+	assert.True(t, TokenExpiry(map[string]interface{}{"exp": 1e300}).IsZero())
+}
+
+func TestTokenExpiryConfig_Validate(t *testing.T) {
+	assert.NoError(t, (&TokenExpiryConfig{}).Validate())
+	assert.NoError(t, (&TokenExpiryConfig{CloseCode: 4001, WarningBefore: time.Minute}).Validate())
+	assert.Error(t, (&TokenExpiryConfig{CloseCode: 1006}).Validate())
+	assert.Error(t, (&TokenExpiryConfig{WarningBefore: -time.Second}).Validate())
+}
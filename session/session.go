@@ -4,11 +4,50 @@
 package session
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ws2wh/ws2wh/backend"
 )
 
+const (
+	// shutdownCloseCode is the close code sent to clients on server shutdown (Going Away)
+	shutdownCloseCode = 1001
+	// shutdownCloseReason is the close reason sent to clients on server shutdown
+	shutdownCloseReason = "Server shutdown"
+	// ReplacedCloseCode is the close code sent to clients whose session ID was taken over by a new connection
+	ReplacedCloseCode = 4009
+	// ReplacedCloseReason is the close reason sent to clients whose session ID was taken over by a new connection
+	ReplacedCloseReason = "Session replaced"
+	// maxIdLength is the maximum length of a session ID
+	maxIdLength = 128
+)
+
+// idPattern lists the characters allowed in session IDs, which are used in reply channel URLs
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._~:@-]+$`)
+
+// ValidateId checks a session ID assigned by the backend or taken from a JWT claim
+// Returns an error if the ID is empty, longer than 128 characters or contains characters
+// other than letters, digits and . _ ~ : @ -
+func ValidateId(id string) error {
+	if id == "" || len(id) > maxIdLength {
+		return fmt.Errorf("session ID must have 1 to %d characters", maxIdLength)
+	}
+
+	if !idPattern.MatchString(id) {
+		return fmt.Errorf("session ID %q contains invalid characters", id)
+	}
+
+	return nil
+}
+
 // Session represents a WebSocket session that bridges communication between a client and backend
 type Session struct {
 	// Id uniquely identifies this WebSocket session
@@ -25,6 +64,37 @@ type Session struct {
 	Logger slog.Logger
 	// JwtClaims contains the JWT payload from the client
 	JwtClaims *string
+	// ClientIp is the IP address of the client
+	ClientIp string
+	// Headers contains the upgrade request headers forwarded to the backend
+	Headers http.Header
+	// ConnectedAt is the time the client connected (set when the connection is ready if empty)
+	ConnectedAt time.Time
+	// Subprotocols lists the WebSocket subprotocols requested by the client
+	Subprotocols []string
+	// Context selects the session context fields sent with each event (optional)
+	Context *ContextConfig
+	// Topics manages the session topic subscriptions (optional)
+	Topics TopicRegistry
+	// Delivery holds the client to backend message delivery parameters (optional)
+	Delivery *DeliveryConfig
+	// Expiry ties the session lifetime to the expiry of the client's JWT (optional)
+	Expiry *TokenExpiryConfig
+	// ExpiresAt is the expiry of the client's JWT (zero if the token does not expire)
+	ExpiresAt time.Time
+	// Verifier verifies the tokens sent by the client to extend the session (required for refreshes)
+	Verifier TokenVerifier
+
+	// connected is set once the client-connected event was sent
+	connected bool
+	// subprotocol is the subprotocol selected by the backend before the upgrade
+	subprotocol string
+	// upgradeHeader holds the headers added by the backend to the upgrade response
+	upgradeHeader http.Header
+	// upgraded is set once the connection is ready, session IDs can no longer be assigned
+	upgraded bool
+	// removed is set once the server dropped the session, later subscriptions are ignored
+	removed atomic.Bool
 }
 
 // NewSession creates a new WebSocket session with the provided parameters
@@ -35,18 +105,35 @@ type Session struct {
 // - Connection: WebSocket connection manager for the client
 // Returns a pointer to the newly created Session
 func NewSession(params SessionParams) *Session {
-	s := Session(params)
-
-	return &s
+	return &Session{
+		Id:           params.Id,
+		ReplyChannel: params.ReplyChannel,
+		QueryString:  params.QueryString,
+		Backend:      params.Backend,
+		Connection:   params.Connection,
+		Logger:       params.Logger,
+		JwtClaims:    params.JwtClaims,
+		ClientIp:     params.ClientIp,
+		Headers:      params.Headers,
+		ConnectedAt:  params.ConnectedAt,
+		Subprotocols: params.Subprotocols,
+		Context:      params.Context,
+		Topics:       params.Topics,
+		Delivery:     params.Delivery,
+		Expiry:       params.Expiry,
+		ExpiresAt:    params.ExpiresAt,
+		Verifier:     params.Verifier,
+	}
 }
 
 // Send transmits a message through the WebSocket connection to the client
 // message contains the raw bytes to send to the client
+// messageType is the WebSocket frame type used for the message
 // Returns an error if sending the message fails
-func (s *Session) Send(message []byte) error {
-	s.Logger.Debug("Sending message to client", "payload", string(message), "queryString", s.QueryString)
+func (s *Session) Send(message []byte, messageType backend.MessageType) error {
+	s.Logger.Debug("Sending message to client", "payload", string(message), "messageType", messageType, "queryString", s.QueryString)
 
-	return s.Connection.Send(message)
+	return s.Connection.Send(message, messageType)
 }
 
 // Close terminates the WebSocket connection for this session
@@ -60,43 +147,192 @@ func (s *Session) Close(closeCode int, closeReason *string) error {
 	return s.Connection.Close(closeCode, closeReason)
 }
 
+// Shutdown closes the WebSocket connection because the server is shutting down
+// Returns an error if closing the connection fails
+func (s *Session) Shutdown() error {
+	s.Logger.Debug("Closing session on server shutdown", "sessionId", s.Id)
+
+	return s.Connection.CloseWithInfo(CloseInfo{
+		Code:   shutdownCloseCode,
+		Reason: shutdownCloseReason,
+		Origin: backend.CloseOriginShutdown,
+	})
+}
+
+// Replace closes the WebSocket connection because a new connection took over the session ID
+// Returns an error if closing the connection fails
+func (s *Session) Replace() error {
+	s.Logger.Info("Closing session replaced by a new connection", "sessionId", s.Id)
+
+	return s.Connection.CloseWithInfo(CloseInfo{
+		Code:   ReplacedCloseCode,
+		Reason: ReplacedCloseReason,
+		Origin: backend.CloseOriginServer,
+	})
+}
+
+// RejectDuplicate notifies the backend that the connection was rejected before the upgrade
+// because the session ID assigned in its client-connected response is in use
+// Nothing is sent if the client-connected event was not sent before the upgrade
+// Returns an error if the backend delivery fails
+func (s *Session) RejectDuplicate() error {
+	s.Remove()
+	if !s.connected {
+		return nil
+	}
+
+	msg := s.newMessage(backend.ClientDisconnected)
+	msg.CloseOrigin = backend.CloseOriginDuplicate
+	return s.Backend.Send(msg, s)
+}
+
+// Connect sends the client-connected event to the backend
+// Receive calls it once the connection is ready, unless it was called before the upgrade
+// so the backend can select the subprotocol
+// Returns an error if the backend delivery fails
+func (s *Session) Connect() error {
+	return s.connect(false)
+}
+
+// Admit sends the client-connected event to the backend before the upgrade
+// The backend admits the connection with a 2xx response
+// Returns a *backend.StatusError if the backend rejected the connection, or an error if the delivery fails
+func (s *Session) Admit() error {
+	return s.connect(true)
+}
+
+func (s *Session) connect(admission bool) error {
+	if s.ConnectedAt.IsZero() {
+		s.ConnectedAt = time.Now().UTC()
+	}
+	s.connected = true
+
+	msg := s.newMessage(backend.ClientConnected)
+	msg.RequestedSubprotocols = s.Subprotocols
+	msg.Admission = admission
+	return s.Backend.Send(msg, s)
+}
+
+// AssignSessionId replaces the generated session ID with the ID chosen by the backend
+// The reply channel and the session logger are updated accordingly
+// Returns an error if the ID is invalid or the connection was already upgraded
+func (s *Session) AssignSessionId(id string) error {
+	if s.upgraded {
+		return fmt.Errorf("session ID can only be assigned before the upgrade")
+	}
+
+	if err := ValidateId(id); err != nil {
+		return err
+	}
+
+	s.Logger.Info("Backend assigned session ID", "sessionId", s.Id, "assignedSessionId", id)
+	s.ReplyChannel = strings.TrimSuffix(s.ReplyChannel, s.Id) + id
+	s.Id = id
+	s.Logger = *slog.Default().With("sessionId", id)
+	return nil
+}
+
+// SelectSubprotocol sets the subprotocol chosen by the backend for the upgrade
+// Returns an error if the client did not request the subprotocol
+func (s *Session) SelectSubprotocol(protocol string) error {
+	if !slices.Contains(s.Subprotocols, protocol) {
+		return fmt.Errorf("subprotocol %q was not requested by the client", protocol)
+	}
+
+	s.Logger.Debug("Backend selected subprotocol", "subprotocol", protocol)
+	s.subprotocol = protocol
+	return nil
+}
+
+// SelectedSubprotocol returns the subprotocol chosen by the backend (empty if none)
+func (s *Session) SelectedSubprotocol() string {
+	return s.subprotocol
+}
+
+// SetUpgradeHeader sets the headers added by the backend to the upgrade response
+func (s *Session) SetUpgradeHeader(header http.Header) {
+	s.upgradeHeader = header
+}
+
+// UpgradeHeader returns the headers added by the backend to the upgrade response (nil if none)
+func (s *Session) UpgradeHeader() http.Header {
+	return s.upgradeHeader
+}
+
+// Subscribe adds this session to the given topics
+// Returns an error if the session does not support topic subscriptions
+func (s *Session) Subscribe(topics []string) error {
+	if s.Topics == nil {
+		return errors.New("topic subscriptions are not supported")
+	}
+
+	s.Logger.Debug("Subscribing session to topics", "topics", topics)
+	s.Topics.Subscribe(s.Id, s.Removed, topics...)
+	return nil
+}
+
+// Remove marks the session as dropped by the server
+// Subscriptions requested afterwards (e.g. by late backend responses) are ignored
+func (s *Session) Remove() {
+	s.removed.Store(true)
+}
+
+// Removed reports whether the session was dropped by the server
+func (s *Session) Removed() bool {
+	return s.removed.Load()
+}
+
+// Unsubscribe removes this session from the given topics
+// Returns an error if the session does not support topic subscriptions
+func (s *Session) Unsubscribe(topics []string) error {
+	if s.Topics == nil {
+		return errors.New("topic subscriptions are not supported")
+	}
+
+	s.Logger.Debug("Unsubscribing session from topics", "topics", topics)
+	s.Topics.Unsubscribe(s.Id, topics...)
+	return nil
+}
+
 // Receive handles the WebSocket session lifecycle and message flow
 // It performs the following:
 // - Notifies the backend when a client connects
-// - Forwards received messages from the client to the backend
-// - Notifies the backend when the client disconnects
+// - Queues received messages from the client for delivery to the backend
+// - Closes the session when the client's JWT expires, unless the client refreshed the token
+// - Notifies the backend when the client disconnects, after the queued messages are delivered
 // - Cleans up the session when done
 func (s *Session) Receive() {
 	s.Logger.Debug("Waiting for connection signal")
 	connSignal := <-s.Connection.Signal()
 	s.Logger.Debug("Received connection signal", "signal", connSignal)
-	if connSignal == ConnectionClosedSignal {
+	if connSignal == ConnectionClosedSignal && !s.connected {
 		s.Logger.Info("Session closed due to connection failure")
 		return
 	}
 
-	if connSignal != ConnectionReadySignal {
+	if connSignal != ConnectionReadySignal && connSignal != ConnectionClosedSignal {
 		s.Logger.Error("Session closed due to unexpected connection signal", "signal", connSignal)
 		return
 	}
 
 	s.Logger.Info("Starting WebSocket session", "sessionId", s.Id)
-
-	msg := backend.BackendMessage{
-		SessionId:    s.Id,
-		ReplyChannel: s.ReplyChannel,
-		Event:        backend.ClientConnected,
-		Payload:      make([]byte, 0),
-		QueryString:  s.QueryString,
-		JwtClaims:    s.JwtClaims,
+	s.upgraded = true
+	if !s.connected {
+		err := s.Connect()
+		if err != nil {
+			s.Logger.Error("Error while sending client connected message", "error", err)
+		}
 	}
 
-	err := s.Backend.Send(msg, s)
-	if err != nil {
-		s.Logger.Error("Error while sending client connected message", "error", err)
-	}
-	msg.Event = backend.ClientDisconnected
+	queue := s.startDelivery()
 	defer func() {
+		queue.stop()
+		msg := s.newMessage(backend.ClientDisconnected)
+		if info := s.Connection.CloseInfo(); info != nil {
+			msg.CloseCode = info.Code
+			msg.CloseReason = info.Reason
+			msg.CloseOrigin = info.Origin
+		}
 		s.Logger.Debug("Sending client disconnected message", "queryString", s.QueryString)
 		err := s.Backend.Send(msg, s)
 		if err != nil {
@@ -104,21 +340,35 @@ func (s *Session) Receive() {
 		}
 	}()
 
+	if connSignal == ConnectionClosedSignal {
+		// closed by the backend in the client-connected response sent before the upgrade
+		return
+	}
+
+	expiry := s.startExpiry()
+	defer expiry.stop()
+
 loop:
 	for {
 		select {
-		case incomingMsg := <-s.Connection.Receiver():
-			s.Logger.Debug("Received message from client, forwarding to backend", "payload", string(incomingMsg), "queryString", s.QueryString)
-			err := s.Backend.Send(backend.BackendMessage{
-				SessionId:    s.Id,
-				ReplyChannel: s.ReplyChannel,
-				Event:        backend.MessageReceived,
-				Payload:      incomingMsg,
-				QueryString:  s.QueryString,
-			}, s)
-			if err != nil {
-				s.Logger.Error("Error while sending message received message", "error", err)
+		case incomingMsg, ok := <-s.Connection.Receiver():
+			if !ok {
+				s.Logger.Info("Session done", "sessionId", s.Id)
+				break loop
+			}
+
+			if token, ok := s.tokenRefresh(incomingMsg); ok {
+				s.refreshToken(token, expiry)
+				continue
 			}
+
+			s.Logger.Debug("Received message from client, forwarding to backend", "payload", string(incomingMsg.Payload), "messageType", incomingMsg.Type, "queryString", s.QueryString)
+			msg := s.newMessage(backend.MessageReceived)
+			msg.Payload = incomingMsg.Payload
+			msg.MessageType = incomingMsg.Type
+			queue.push(msg)
+		case <-expiry.C():
+			s.onExpiryTimer(expiry)
 		case <-s.Connection.Signal():
 			s.Logger.Info("Session done", "sessionId", s.Id)
 			break loop
@@ -130,10 +380,35 @@ loop:
 // It provides methods for sending messages, receiving messages, checking connection status,
 // and closing the connection
 type WebsocketConn interface {
-	Send(payload []byte) error
-	Receiver() <-chan []byte
+	Send(payload []byte, messageType backend.MessageType) error
+	Receiver() <-chan Message
 	Signal() <-chan ConnectionSignal
+	// Close closes the connection on behalf of the backend
 	Close(closeCode int, closeReason *string) error
+	// CloseWithInfo closes the connection and records the close code, reason and origin reported to the backend
+	CloseWithInfo(info CloseInfo) error
+	// CloseInfo returns why the connection was closed, nil if the reason is not known
+	CloseInfo() *CloseInfo
+	// Subprotocol returns the negotiated subprotocol (empty before the upgrade or if none)
+	Subprotocol() string
+}
+
+// CloseInfo describes why a WebSocket connection was closed
+type CloseInfo struct {
+	// Code is the WebSocket close code
+	Code int
+	// Reason is the close reason text
+	Reason string
+	// Origin identifies who closed the connection
+	Origin backend.CloseOrigin
+}
+
+// Message represents a single data frame received from the WebSocket client
+type Message struct {
+	// Type is the WebSocket frame type of the message
+	Type backend.MessageType
+	// Payload contains the raw message data bytes
+	Payload []byte
 }
 
 // SessionParams contains the configuration parameters for creating a new Session
@@ -152,6 +427,35 @@ type SessionParams struct {
 	Logger slog.Logger
 	// JwtClaims contains the JWT payload from the client
 	JwtClaims *string
+	// ClientIp is the IP address of the client
+	ClientIp string
+	// Headers contains the upgrade request headers forwarded to the backend
+	Headers http.Header
+	// ConnectedAt is the time the client connected (set when the connection is ready if empty)
+	ConnectedAt time.Time
+	// Subprotocols lists the WebSocket subprotocols requested by the client
+	Subprotocols []string
+	// Context selects the session context fields sent with each event (optional)
+	Context *ContextConfig
+	// Topics manages the session topic subscriptions (optional)
+	Topics TopicRegistry
+	// Delivery holds the client to backend message delivery parameters (optional)
+	Delivery *DeliveryConfig
+	// Expiry ties the session lifetime to the expiry of the client's JWT (optional)
+	Expiry *TokenExpiryConfig
+	// ExpiresAt is the expiry of the client's JWT (zero if the token does not expire)
+	ExpiresAt time.Time
+	// Verifier verifies the tokens sent by the client to extend the session (required for refreshes)
+	Verifier TokenVerifier
+}
+
+// TopicRegistry defines the interface for managing session subscriptions to named topics
+type TopicRegistry interface {
+	// Subscribe adds the session to the given topics unless removed reports the session as removed
+	// removed must be checked atomically with the subscription so it cannot race with the session cleanup
+	Subscribe(sessionId string, removed func() bool, topics ...string)
+	// Unsubscribe removes the session from the given topics
+	Unsubscribe(sessionId string, topics ...string)
 }
 
 type ConnectionSignal int
@@ -1,7 +1,10 @@
 package session
 
 import (
+	"bytes"
 	"log/slog"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,27 +16,33 @@ import (
 type MockWebsocketConn struct {
 	sendCalled      bool
 	closeCalled     bool
-	receiverChan    chan []byte
+	receiverChan    chan Message
 	doneChan        chan ConnectionSignal
 	sendError       error
 	closeError      error
 	lastCloseCode   int
 	lastCloseReason *string
+	lastMessageType backend.MessageType
+	lastPayload     []byte
+	closeInfo       *CloseInfo
+	subprotocol     string
 }
 
 func NewMockWebsocketConn() *MockWebsocketConn {
 	return &MockWebsocketConn{
-		receiverChan: make(chan []byte, 64),
+		receiverChan: make(chan Message, 64),
 		doneChan:     make(chan ConnectionSignal),
 	}
 }
 
-func (m *MockWebsocketConn) Send(payload []byte) error {
+func (m *MockWebsocketConn) Send(payload []byte, messageType backend.MessageType) error {
 	m.sendCalled = true
+	m.lastMessageType = messageType
+	m.lastPayload = payload
 	return m.sendError
 }
 
-func (m *MockWebsocketConn) Receiver() <-chan []byte {
+func (m *MockWebsocketConn) Receiver() <-chan Message {
 	return m.receiverChan
 }
 
@@ -48,6 +57,22 @@ func (m *MockWebsocketConn) Close(closeCode int, closeReason *string) error {
 	return m.closeError
 }
 
+func (m *MockWebsocketConn) CloseWithInfo(info CloseInfo) error {
+	m.closeCalled = true
+	m.lastCloseCode = info.Code
+	m.lastCloseReason = &info.Reason
+	m.closeInfo = &info
+	return m.closeError
+}
+
+func (m *MockWebsocketConn) CloseInfo() *CloseInfo {
+	return m.closeInfo
+}
+
+func (m *MockWebsocketConn) Subprotocol() string {
+	return m.subprotocol
+}
+
 // MockBackend implements backend.Backend for testing
 type MockBackend struct {
 	messages []backend.BackendMessage
@@ -80,10 +105,11 @@ func TestSession_Send(t *testing.T) {
 	session := &Session{Connection: conn, Logger: *slog.Default()}
 
 	message := []byte("test message")
-	err := session.Send(message)
+	err := session.Send(message, backend.BinaryMessage)
 
 	assert.NoError(t, err, "Send should not return error")
 	assert.True(t, conn.sendCalled, "Send should be called on WebsocketConn")
+	assert.Equal(t, backend.BinaryMessage, conn.lastMessageType, "Send should preserve message type")
 }
 
 func TestSession_Close(t *testing.T) {
@@ -101,6 +127,20 @@ func TestSession_Close(t *testing.T) {
 	}
 }
 
+func TestSession_Shutdown(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	session := &Session{Connection: conn, Logger: *slog.Default()}
+
+	err := session.Shutdown()
+
+	assert.NoError(t, err, "Shutdown should not return error")
+	assert.Equal(t, &CloseInfo{
+		Code:   shutdownCloseCode,
+		Reason: shutdownCloseReason,
+		Origin: backend.CloseOriginShutdown,
+	}, conn.closeInfo)
+}
+
 // Test is flaky
 func TestSession_Receive(t *testing.T) {
 	conn := NewMockWebsocketConn()
@@ -119,7 +159,7 @@ func TestSession_Receive(t *testing.T) {
 		conn.doneChan <- ConnectionReadySignal
 		time.Sleep(time.Millisecond * 100)
 		// Simulate message received
-		conn.receiverChan <- []byte("test message")
+		conn.receiverChan <- Message{Type: backend.BinaryMessage, Payload: []byte("test message")}
 		time.Sleep(time.Millisecond * 100)
 		// Simulate closed signal
 		conn.doneChan <- ConnectionClosedSignal
@@ -143,8 +183,162 @@ func TestSession_Receive(t *testing.T) {
 		"Second message should be MessageReceived")
 	assert.Equal(t, "test message", string(mockBackend.messages[1].Payload),
 		"Message payload should match")
+	assert.Equal(t, backend.BinaryMessage, mockBackend.messages[1].MessageType,
+		"Message type should match")
 
 	// Verify disconnect message
 	assert.Equal(t, backend.ClientDisconnected, mockBackend.messages[2].Event,
 		"Last message should be ClientDisconnected")
 }
+
+func TestSession_ReceiveCloseInfo(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	conn.closeInfo = &CloseInfo{Code: 1001, Reason: "Idle timeout", Origin: backend.CloseOriginTimeout}
+	mockBackend := &MockBackend{}
+	session := &Session{
+		Id:         "test-session",
+		Backend:    mockBackend,
+		Connection: conn,
+		Logger:     *slog.Default(),
+	}
+
+	go func() {
+		conn.doneChan <- ConnectionReadySignal
+		conn.doneChan <- ConnectionClosedSignal
+	}()
+
+	session.Receive()
+
+	if assert.Len(t, mockBackend.messages, 2, "Should have 2 backend messages") {
+		disconnected := mockBackend.messages[1]
+		assert.Equal(t, backend.ClientDisconnected, disconnected.Event)
+		assert.Equal(t, 1001, disconnected.CloseCode, "Close code should be forwarded")
+		assert.Equal(t, "Idle timeout", disconnected.CloseReason, "Close reason should be forwarded")
+		assert.Equal(t, backend.CloseOriginTimeout, disconnected.CloseOrigin, "Close origin should be forwarded")
+	}
+}
+
+func TestSession_ReceiveClientMetadata(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	mockBackend := &MockBackend{}
+	session := &Session{
+		Id:         "test-session",
+		Backend:    mockBackend,
+		Connection: conn,
+		Logger:     *slog.Default(),
+		ClientIp:   "203.0.113.7",
+		Headers:    http.Header{"User-Agent": {"test-agent"}},
+	}
+
+	conn.receiverChan <- Message{Type: backend.TextMessage, Payload: []byte("test message")}
+	close(conn.receiverChan)
+	go func() {
+		conn.doneChan <- ConnectionReadySignal
+	}()
+
+	session.Receive()
+
+	if assert.Len(t, mockBackend.messages, 3, "Should have 3 backend messages") {
+		for _, msg := range mockBackend.messages {
+			assert.Equal(t, "203.0.113.7", msg.ClientIp, "Client IP should be sent with %s", msg.Event)
+			assert.Equal(t, "test-agent", msg.Headers.Get("User-Agent"), "Headers should be sent with %s", msg.Event)
+		}
+	}
+}
+
+func TestSession_Connect(t *testing.T) {
+	conn := NewMockWebsocketConn()
+	mockBackend := &MockBackend{}
+	session := &Session{
+		Id:           "test-session",
+		Backend:      mockBackend,
+		Connection:   conn,
+		Logger:       *slog.Default(),
+		Subprotocols: []string{"graphql-ws", "mqtt"},
+	}
+
+	assert.NoError(t, session.Connect())
+	if assert.Len(t, mockBackend.messages, 1) {
+		assert.Equal(t, backend.ClientConnected, mockBackend.messages[0].Event)
+		assert.Equal(t, []string{"graphql-ws", "mqtt"}, mockBackend.messages[0].RequestedSubprotocols)
+	}
+	assert.False(t, session.ConnectedAt.IsZero(), "Connect should set the connection time")
+
+	assert.Error(t, session.SelectSubprotocol("unknown"), "only requested subprotocols can be selected")
+	assert.Empty(t, session.SelectedSubprotocol())
+	assert.NoError(t, session.SelectSubprotocol("mqtt"))
+	assert.Equal(t, "mqtt", session.SelectedSubprotocol())
+
+	// Receive must not send a second client-connected event
+	go func() {
+		conn.doneChan <- ConnectionReadySignal
+		conn.doneChan <- ConnectionClosedSignal
+	}()
+	session.Receive()
+
+	if assert.Len(t, mockBackend.messages, 2) {
+		assert.Equal(t, backend.ClientDisconnected, mockBackend.messages[1].Event)
+	}
+}
+
+func TestSession_AssignSessionId(t *testing.T) {
+	session := &Session{
+		Id:           "generated",
+		ReplyChannel: "http://test.com/reply/generated",
+		Connection:   NewMockWebsocketConn(),
+		Logger:       *slog.Default(),
+	}
+
+	assert.Error(t, session.AssignSessionId("user/42"), "IDs must be usable in reply channel URLs")
+	var logs bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	defer slog.SetDefault(defaultLogger)
+
+	assert.NoError(t, session.AssignSessionId("user-42:device-7"))
+	assert.Equal(t, "user-42:device-7", session.Id)
+	assert.Equal(t, "http://test.com/reply/user-42:device-7", session.ReplyChannel)
+
+	session.Logger.Info("assigned")
+	assert.Contains(t, logs.String(), `"sessionId":"user-42:device-7"`, "logs should carry the assigned ID")
+
+	session.upgraded = true
+	assert.Error(t, session.AssignSessionId("user-43"), "IDs cannot change after the upgrade")
+}
+
+// mockTopicRegistry implements TopicRegistry for testing
+type mockTopicRegistry struct {
+	subscriptions map[string][]string
+}
+
+func (r *mockTopicRegistry) Subscribe(sessionId string, removed func() bool, topics ...string) {
+	if removed() {
+		return
+	}
+	r.subscriptions[sessionId] = append(r.subscriptions[sessionId], topics...)
+}
+
+func (r *mockTopicRegistry) Unsubscribe(sessionId string, topics ...string) {}
+
+func TestSession_SubscribeAfterRemove(t *testing.T) {
+	registry := &mockTopicRegistry{subscriptions: map[string][]string{}}
+	session := &Session{
+		Id:     "test-session",
+		Topics: registry,
+		Logger: *slog.Default(),
+	}
+
+	assert.NoError(t, session.Subscribe([]string{"news"}))
+	session.Remove()
+
+	// A late backend response to a message received before the session was removed
+	assert.NoError(t, session.Subscribe([]string{"chat"}))
+	assert.Equal(t, []string{"news"}, registry.subscriptions["test-session"], "Removed sessions must not be subscribed")
+}
+
+func TestValidateId(t *testing.T) {
+	assert.NoError(t, ValidateId("user-42:device_7@example.com"))
+	assert.Error(t, ValidateId(""))
+	assert.Error(t, ValidateId("a b"))
+	assert.Error(t, ValidateId(strings.Repeat("a", 129)))
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	AdmissionPort        = "3014"
+	AdmissionBackendHost = ":5014"
+	AdmissionBackendUrl  = "http://localhost:5014"
+)
+
+// TestBackendAdmission tests that connections are only upgraded when the backend admits them
+func TestBackendAdmission(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(AdmissionBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	config := CreateTestConfig(AdmissionPort, AdmissionBackendUrl)
+	config.FrontendConfig = &frontend.Config{BackendAdmission: true}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	t.Run("Rejected", func(t *testing.T) {
+		wh.QueueStatus(http.StatusForbidden)
+		wh.QueueResponse([]byte("not allowed"))
+		wh.QueueHeader(http.Header{
+			backend.UpgradeHeaderPrefix + "X-Reason": {"banned"},
+		})
+
+		_, res, err := websocket.DefaultDialer.Dial("ws://localhost:"+AdmissionPort, nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+		if assert.NotNil(t, res) {
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+			assert.Equal(t, "banned", res.Header.Get("X-Reason"))
+			body, _ := io.ReadAll(res.Body)
+			assert.Equal(t, "not allowed", string(body))
+		}
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		select {
+		case msg := <-wh.messages:
+			assert.Fail(t, "rejected connections should not send further events", msg.Event.String())
+		case <-time.After(time.Millisecond * 100):
+		}
+	})
+
+	t.Run("Admitted", func(t *testing.T) {
+		wh.QueueResponse([]byte("welcome"))
+		wh.QueueHeader(http.Header{
+			"Set-Cookie": {"session=abc123"},
+		})
+
+		conn, res, err := websocket.DefaultDialer.Dial("ws://localhost:"+AdmissionPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		assert.Equal(t, "session=abc123", res.Header.Get("Set-Cookie"))
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "welcome", string(msg), "admission response body should be sent after the upgrade")
+
+		conn.Close()
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+	})
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	ClosePort        = "3008"
+	CloseBackendHost = ":5008"
+	CloseBackendUrl  = "http://localhost:5008"
+)
+
+// TestCloseOrigin tests that client-disconnected events report the close code, reason and origin
+func TestCloseOrigin(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(CloseBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	wsSrv := CreateTestWsWithConfig(CreateTestConfig(ClosePort, CloseBackendUrl))
+	wsSrv.Start()
+	time.Sleep(time.Millisecond * 10)
+
+	t.Run("Client", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+ClosePort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		err = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(4000, "bye"))
+		assert.NoError(t, err)
+
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+		assert.Equal(t, onConnected.SessionId, onClosed.SessionId)
+		assert.Equal(t, 4000, onClosed.CloseCode)
+		assert.Equal(t, "bye", onClosed.CloseReason)
+		assert.Equal(t, backend.CloseOriginClient, onClosed.CloseOrigin)
+	})
+
+	t.Run("Backend", func(t *testing.T) {
+		wh.QueueHeader(http.Header{
+			backend.CommandHeader:     {backend.TerminateSessionCommand},
+			backend.CloseCodeHeader:   {"4001"},
+			backend.CloseReasonHeader: {"done"},
+		})
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+ClosePort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, 4001), "should receive close frame, got %v", err)
+
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+		assert.Equal(t, 4001, onClosed.CloseCode)
+		assert.Equal(t, "done", onClosed.CloseReason)
+		assert.Equal(t, backend.CloseOriginBackend, onClosed.CloseOrigin)
+	})
+
+	t.Run("Server Shutdown", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+ClosePort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		wsSrv.Stop()
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "should receive close frame, got %v", err)
+
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+		assert.Equal(t, websocket.CloseGoingAway, onClosed.CloseCode)
+		assert.Equal(t, "Server shutdown", onClosed.CloseReason)
+		assert.Equal(t, backend.CloseOriginShutdown, onClosed.CloseOrigin)
+	})
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/http-middleware/jwt"
+	"github.com/ws2wh/ws2wh/server"
+	"github.com/ws2wh/ws2wh/session"
+)
+
+const (
+	ExpiryWsPort      = "3019"
+	ExpiryWsUrl       = "ws://localhost:3019"
+	ExpiryBackendHost = ":5019"
+	ExpiryBackendUrl  = "http://localhost:5019"
+)
+
+// TestTokenExpiry tests sessions closed on JWT expiry and extended with an in-band token refresh
+func TestTokenExpiry(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+
+	config := CreateTestConfig(ExpiryWsPort, ExpiryBackendUrl)
+	config.JwtConfig = &jwt.JwtConfig{
+		Enabled:      true,
+		QueryParam:   "token",
+		SecretSource: &jwt.RawJWKSProvider{Content: mustMarshalJwks(t, key)},
+	}
+	config.TokenExpiryConfig = &session.TokenExpiryConfig{
+		WarningBefore: 300 * time.Millisecond,
+	}
+
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(ExpiryBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	// make sure ws server is up
+	time.Sleep(time.Millisecond * 10)
+
+	expiringToken := func(ttl time.Duration) string {
+		exp := float64(time.Now().Add(ttl).UnixMilli()) / 1000
+		return signTestToken(t, key, map[string]interface{}{"sub": "test-subject", "exp": exp})
+	}
+
+	t.Run("Closed On Expiry", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(ExpiryWsUrl+"?token="+expiringToken(600*time.Millisecond), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		_, warning, err := conn.ReadMessage()
+		if assert.NoError(t, err, "warning should be sent before the expiry") {
+			assert.Contains(t, string(warning), `"type":"token-expiring"`)
+		}
+
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, session.DefaultExpiryCloseCode), "connection should be closed on expiry: %v", err)
+
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+		assert.Equal(t, session.DefaultExpiryCloseCode, onDisconnected.CloseCode)
+		assert.Equal(t, backend.CloseOriginTokenExpired, onDisconnected.CloseOrigin)
+	})
+
+	t.Run("Extended By Refresh", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(ExpiryWsUrl+"?token="+expiringToken(600*time.Millisecond), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		refresh := fmt.Sprintf(`{"type":"token-refresh","token":"%s"}`, expiringToken(time.Minute))
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(refresh)))
+		time.Sleep(time.Second)
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("still open")))
+		onMessage := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.MessageReceived, onMessage.Event, "refresh message should not be forwarded")
+		assert.Equal(t, "still open", string(onMessage.Payload))
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+}
@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	FanoutWsPort      = "3004"
+	FanoutWsUrl       = "ws://localhost:3004"
+	FanoutHttpUrl     = "http://localhost:3004"
+	FanoutBackendHost = ":5004"
+	FanoutBackendUrl  = "http://localhost:5004"
+)
+
+// TestBroadcastAndMulticast tests delivering a single backend message to many sessions
+func TestBroadcastAndMulticast(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wsSrv := CreateTestWsWithConfig(CreateTestConfig(FanoutWsPort, FanoutBackendUrl))
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(FanoutBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	time.Sleep(time.Millisecond * 10)
+
+	connA, idA := connectFanoutClient(t, wh)
+	defer connA.Close()
+	connB, idB := connectFanoutClient(t, wh)
+	defer connB.Close()
+
+	t.Run("Broadcast", func(t *testing.T) {
+		chanA := make(chan []byte, 1)
+		chanB := make(chan []byte, 1)
+		go captureMessage(connA, chanA)
+		go captureMessage(connB, chanB)
+
+		expectedMsg := []byte(uuid.NewString())
+		results := callFanout(t, FanoutHttpUrl+"/broadcast", expectedMsg)
+
+		assert.Len(t, results, 2, "should return result for every session")
+		for _, result := range results {
+			assert.True(t, result.Success, "delivery to %s should succeed", result.SessionId)
+		}
+		assert.Equal(t, expectedMsg, waitForMessage(t, chanA))
+		assert.Equal(t, expectedMsg, waitForMessage(t, chanB))
+	})
+
+	t.Run("Multicast", func(t *testing.T) {
+		chanA := make(chan []byte, 1)
+		go captureMessage(connA, chanA)
+
+		unknownId := uuid.NewString()
+		expectedMsg := uuid.NewString()
+		body, err := json.Marshal(server.MulticastRequest{
+			SessionIds: []string{idA, unknownId},
+			Message:    expectedMsg,
+		})
+		assert.NoError(t, err)
+		results := callFanout(t, FanoutHttpUrl+"/multicast", body)
+
+		if assert.Len(t, results, 2, "should return result for every requested session") {
+			assert.Equal(t, idA, results[0].SessionId)
+			assert.True(t, results[0].Success)
+			assert.Equal(t, unknownId, results[1].SessionId)
+			assert.False(t, results[1].Success)
+			assert.Equal(t, "NOT_FOUND", results[1].Message)
+		}
+		assert.Equal(t, []byte(expectedMsg), waitForMessage(t, chanA))
+		assert.NotEqual(t, idA, idB)
+	})
+
+	t.Run("Multicast With Repeated IDs", func(t *testing.T) {
+		chanA := make(chan []byte, 2)
+		go captureMessage(connA, chanA)
+
+		expectedMsg := uuid.NewString()
+		body, err := json.Marshal(server.MulticastRequest{
+			SessionIds: []string{idA, idB, idA},
+			Message:    expectedMsg,
+		})
+		assert.NoError(t, err)
+		results := callFanout(t, FanoutHttpUrl+"/multicast", body)
+
+		if assert.Len(t, results, 2, "should return a single result per session") {
+			assert.Equal(t, idA, results[0].SessionId)
+			assert.Equal(t, idB, results[1].SessionId)
+		}
+		assert.Equal(t, []byte(expectedMsg), waitForMessage(t, chanA))
+		_, _, err = connB.ReadMessage()
+		assert.NoError(t, err)
+
+		// a repeated delivery would be read before the next message
+		go captureMessage(connA, chanA)
+		nextMsg := uuid.NewString()
+		body, err = json.Marshal(server.MulticastRequest{SessionIds: []string{idA}, Message: nextMsg})
+		assert.NoError(t, err)
+		callFanout(t, FanoutHttpUrl+"/multicast", body)
+		assert.Equal(t, []byte(nextMsg), waitForMessage(t, chanA), "should deliver the message once")
+	})
+
+	t.Run("Binary Multicast", func(t *testing.T) {
+		expectedMsg := []byte{0x00, 0xff, 0x10, 0x80}
+		body, err := json.Marshal(server.MulticastRequest{
+			SessionIds: []string{idA},
+			Data:       expectedMsg,
+		})
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, FanoutHttpUrl+"/multicast", bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set(backend.MessageTypeHeader, "binary")
+		res, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+
+		mt, data, err := connA.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, mt)
+		assert.Equal(t, expectedMsg, data)
+	})
+
+	t.Run("Invalid Multicast Request", func(t *testing.T) {
+		res, err := http.Post(FanoutHttpUrl+"/multicast", "application/json", bytes.NewReader([]byte("not-json")))
+		if assert.NoError(t, err) {
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		}
+
+		res, err = http.Post(FanoutHttpUrl+"/multicast", "application/json",
+			bytes.NewReader([]byte(`{"sessionIds": [], "message": "text", "data": "AP8="}`)))
+		if assert.NoError(t, err) {
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode, "message and data are exclusive")
+		}
+	})
+}
+
+func connectFanoutClient(t *testing.T, wh *TestWebhook) (*websocket.Conn, string) {
+	conn, _, err := websocket.DefaultDialer.Dial(FanoutWsUrl, nil)
+	assert.NoError(t, err, "should accept websocket connection")
+	onConnected := wh.WaitForMessage(t, TestTimeout)
+	assert.Equal(t, backend.ClientConnected, onConnected.Event)
+	return conn, onConnected.SessionId
+}
+
+func callFanout(t *testing.T, url string, body []byte) []server.DeliveryResult {
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	response := struct {
+		Success bool                    `json:"success"`
+		Message []server.DeliveryResult `json:"message"`
+	}{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	assert.True(t, response.Success)
+	return response.Message
+}
+
+const (
+	SlowFanoutWsPort      = "3020"
+	SlowFanoutBackendHost = ":5020"
+	SlowFanoutBackendUrl  = "http://localhost:5020"
+)
+
+// TestFanoutSlowConsumers tests that clients that do not read do not delay the delivery to other clients
+func TestFanoutSlowConsumers(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelInfo,
+	})
+
+	config := CreateTestConfig(SlowFanoutWsPort, SlowFanoutBackendUrl)
+	config.FrontendConfig = &frontend.Config{WriteWait: 10 * time.Second, OutboundQueueSize: 1}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(SlowFanoutBackendHost)
+	wh.Start()
+	defer wh.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	connect := func() (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+SlowFanoutWsPort, nil)
+		assert.NoError(t, err, "should accept websocket connection")
+		return conn, wh.WaitForMessage(t, TestTimeout).SessionId
+	}
+
+	// the slow client never reads, so its writer stalls on the first message and its queue fills up
+	slow, slowId := connect()
+	defer slow.Close()
+	fast, fastId := connect()
+	defer fast.Close()
+
+	received := make(chan []byte, 3)
+	go func() {
+		for {
+			_, data, err := fast.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- data
+		}
+	}()
+
+	body, err := json.Marshal(server.MulticastRequest{
+		SessionIds: []string{slowId, fastId},
+		Data:       bytes.Repeat([]byte("x"), 4<<20),
+	})
+	assert.NoError(t, err)
+	for range 3 {
+		go func() {
+			res, err := http.Post("http://localhost:"+SlowFanoutWsPort+"/multicast", "application/json", bytes.NewReader(body))
+			if err == nil {
+				res.Body.Close()
+			}
+		}()
+	}
+
+	for range 3 {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "the fast client should not wait for the slow client")
+			return
+		}
+	}
+}
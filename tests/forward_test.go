@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	ForwardPort        = "3009"
+	ForwardProxyPort   = "3010"
+	ForwardBackendHost = ":5009"
+	ForwardBackendUrl  = "http://localhost:5009"
+)
+
+// TestForwardClientMetadata tests that the client IP and allowlisted headers are sent with every event
+func TestForwardClientMetadata(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(ForwardBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	header := http.Header{
+		"User-Agent":      {"ws2wh-test"},
+		"X-Tenant":        {"tenant-a"},
+		"X-Secret":        {"not-forwarded"},
+		"X-Forwarded-For": {"203.0.113.7, 198.51.100.1"},
+	}
+
+	t.Run("Direct", func(t *testing.T) {
+		config := CreateTestConfig(ForwardPort, ForwardBackendUrl)
+		config.ForwardConfig = &server.ForwardConfig{
+			Headers: []string{"user-agent", "X-Tenant"},
+		}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+ForwardPort, header)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+		for _, event := range []backend.WsEvent{backend.ClientConnected, backend.MessageReceived, backend.ClientDisconnected} {
+			msg := wh.WaitForMessage(t, TestTimeout)
+			if event == backend.MessageReceived {
+				assert.NoError(t, conn.Close())
+			}
+			assert.Equal(t, event, msg.Event)
+			assert.Equal(t, "127.0.0.1", msg.ClientIp, "untrusted X-Forwarded-For should be ignored on %s", event)
+			assert.Equal(t, "ws2wh-test", msg.Headers.Get("User-Agent"), "header should be forwarded on %s", event)
+			assert.Equal(t, "tenant-a", msg.Headers.Get("X-Tenant"), "header should be forwarded on %s", event)
+			assert.Empty(t, msg.Headers.Get("X-Secret"), "header should not be forwarded on %s", event)
+		}
+	})
+
+	t.Run("Trusted Proxy", func(t *testing.T) {
+		config := CreateTestConfig(ForwardProxyPort, ForwardBackendUrl)
+		config.ForwardConfig = &server.ForwardConfig{
+			TrustedProxies: []string{"127.0.0.1", "198.51.100.0/24"},
+		}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+ForwardProxyPort, header)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		assert.Equal(t, "203.0.113.7", onConnected.ClientIp, "client IP should be read from trusted X-Forwarded-For")
+		assert.Empty(t, onConnected.Headers, "no headers should be forwarded by default")
+	})
+}
@@ -48,6 +48,12 @@ func TestWebsocketToWebhook(t *testing.T) {
 		websocketClientDisconnected(conn, wh, sessionId, t)
 	})
 
+	t.Run("Binary Message Flow", func(t *testing.T) {
+		conn, sessionId, replyUrl := clientConnected(wh, t, "")
+		binaryMessagesPreserved(conn, wh, sessionId, replyUrl, t)
+		websocketClientDisconnected(conn, wh, sessionId, t)
+	})
+
 	t.Run("Session Termination by Backend", func(t *testing.T) {
 		conn, _, replyUrl := clientConnected(wh, t, "")
 		sessionTerminatedByBackend(conn, replyUrl, t)
@@ -116,7 +122,7 @@ func backendMessageSent(conn *websocket.Conn, replyUrl string, t *testing.T) {
 func websocketClientMessageWithImmediateBackendResponse(conn *websocket.Conn, wh *TestWebhook, sessionId string, t *testing.T) {
 	assert := assert.New(t)
 	expectedResponse := []byte(uuid.NewString())
-	wh.responses = append(wh.responses, expectedResponse)
+	wh.QueueResponse(expectedResponse)
 	wsClientChan := make(chan []byte, 64)
 	defer close(wsClientChan)
 	go captureMessage(conn, wsClientChan)
@@ -196,6 +202,43 @@ func sessionTerminatedByBackend(conn *websocket.Conn, replyUrl string, t *testin
 	assert.True(closed)
 }
 
+// binaryMessagesPreserved tests that binary frames are forwarded to the backend as binary
+// and that the backend can send binary frames to the client in both response and reply channel
+func binaryMessagesPreserved(conn *websocket.Conn, wh *TestWebhook, sessionId string, replyUrl string, t *testing.T) {
+	assert := assert.New(t)
+
+	clientMsg := []byte{0x00, 0xff, 0x10, 0x80}
+	immediateResponse := []byte{0x01, 0xfe}
+	wh.QueueHeader(http.Header{backend.MessageTypeHeader: {"binary"}})
+	wh.QueueResponse(immediateResponse)
+
+	err := conn.WriteMessage(websocket.BinaryMessage, clientMsg)
+	assert.Nil(err, "should successfully send binary websocket message via ws client")
+
+	onMessage := wh.WaitForMessage(t, TestTimeout)
+	assert.Equal(sessionId, onMessage.SessionId)
+	assert.Equal(backend.BinaryMessage, onMessage.MessageType, "backend should receive binary message type")
+	assert.Equal(clientMsg, onMessage.Payload, "backend should receive exact binary payload")
+
+	mt, actualResponse, err := conn.ReadMessage()
+	assert.Nil(err)
+	assert.Equal(websocket.BinaryMessage, mt, "immediate backend response should be sent as binary frame")
+	assert.Equal(immediateResponse, actualResponse)
+
+	replyMsg := []byte{0x02, 0xfd}
+	req, _ := http.NewRequest(http.MethodPost, replyUrl, bytes.NewReader(replyMsg))
+	req.Header.Set(backend.MessageTypeHeader, "binary")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(err)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+
+	mt, actualReply, err := conn.ReadMessage()
+	assert.Nil(err)
+	assert.Equal(websocket.BinaryMessage, mt, "reply channel message should be sent as binary frame")
+	assert.Equal(replyMsg, actualReply)
+}
+
 // captureMessage reads a single message from the WebSocket connection
 // and sends it to the output channel
 func captureMessage(ws *websocket.Conn, out chan []byte) {
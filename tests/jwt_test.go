@@ -0,0 +1,243 @@
+package tests
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/http-middleware/jwt"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	JwtWsPort      = "3001"
+	JwtWsUrl       = "ws://localhost:3001"
+	JwtBackendHost = ":5001"
+	JwtBackendUrl  = "http://localhost:5001"
+	JwtTestKeyId   = "test-key-id"
+
+	JwtSourcesWsPort      = "3018"
+	JwtSourcesWsUrl       = "ws://localhost:3018"
+	JwtSourcesBackendHost = ":5018"
+	JwtSourcesBackendUrl  = "http://localhost:5018"
+)
+
+// TestJwtProtectedUpgrade tests that the WebSocket upgrade route is protected
+// by the JWT authorizer when JWT authentication is enabled
+func TestJwtProtectedUpgrade(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+
+	config := CreateTestConfig(JwtWsPort, JwtBackendUrl)
+	config.JwtConfig = &jwt.JwtConfig{
+		Enabled:      true,
+		QueryParam:   "token",
+		SecretSource: &jwt.RawJWKSProvider{Content: mustMarshalJwks(t, key)},
+		Issuer:       "test-issuer",
+	}
+
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(JwtBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	// make sure ws server is up
+	time.Sleep(time.Millisecond * 10)
+
+	t.Run("Upgrade Without Token Rejected", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial(JwtWsUrl, nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, "should reject websocket connection")
+		assert.Nil(t, conn)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Upgrade With Invalid Token Rejected", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial(JwtWsUrl+"?token=invalid", nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, "should reject websocket connection")
+		assert.Nil(t, conn)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Upgrade With Wrong Issuer Rejected", func(t *testing.T) {
+		token := signTestToken(t, key, map[string]interface{}{"iss": "other-issuer", "sub": "test-subject"})
+		conn, resp, err := websocket.DefaultDialer.Dial(JwtWsUrl+"?token="+token, nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, "should reject websocket connection")
+		assert.Nil(t, conn)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Upgrade With Valid Token Accepted", func(t *testing.T) {
+		token := signTestToken(t, key, map[string]interface{}{"iss": "test-issuer", "sub": "test-subject"})
+		conn, _, err := websocket.DefaultDialer.Dial(JwtWsUrl+"?token="+token, nil)
+		if !assert.NoError(t, err, "should accept websocket connection") {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		if assert.NotNil(t, onConnected.JwtClaims, "backend should receive JWT claims") {
+			claims := make(map[string]interface{})
+			assert.NoError(t, json.Unmarshal([]byte(*onConnected.JwtClaims), &claims))
+			assert.Equal(t, "test-subject", claims["sub"])
+		}
+	})
+}
+
+// TestJwtTokenSources tests tokens sent in the Authorization header and as a subprotocol
+func TestJwtTokenSources(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.NoError(t, err)
+
+	config := CreateTestConfig(JwtSourcesWsPort, JwtSourcesBackendUrl)
+	config.JwtConfig = &jwt.JwtConfig{
+		Enabled:      true,
+		TokenSources: []jwt.TokenSource{jwt.TokenSourceHeader, jwt.TokenSourceSubprotocol},
+		SecretSource: &jwt.RawJWKSProvider{Content: mustMarshalJwks(t, key)},
+	}
+	config.FrontendConfig = &frontend.Config{
+		Subprotocols: []string{"graphql-ws"},
+	}
+
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(JwtSourcesBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	// make sure ws server is up
+	time.Sleep(time.Millisecond * 10)
+
+	token := signTestToken(t, key, map[string]interface{}{"sub": "test-subject"})
+
+	t.Run("Query Param Not Accepted", func(t *testing.T) {
+		conn, resp, err := websocket.DefaultDialer.Dial(JwtSourcesWsUrl+"?token="+token, nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, "should reject websocket connection")
+		assert.Nil(t, conn)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+	})
+
+	t.Run("Authorization Header", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(JwtSourcesWsUrl, http.Header{
+			"Authorization": {"Bearer " + token},
+		})
+		if !assert.NoError(t, err, "should accept websocket connection") {
+			return
+		}
+		defer conn.Close()
+
+		assert.Empty(t, conn.Subprotocol())
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		assert.NotNil(t, onConnected.JwtClaims)
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+
+	t.Run("Subprotocol Marker Echoed", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"bearer", token}}
+		conn, _, err := dialer.Dial(JwtSourcesWsUrl, nil)
+		if !assert.NoError(t, err, "should accept websocket connection") {
+			return
+		}
+		defer conn.Close()
+
+		assert.Equal(t, "bearer", conn.Subprotocol())
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		assert.Empty(t, onConnected.RequestedSubprotocols, "token should not be forwarded")
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+
+	t.Run("Subprotocol Negotiated", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"graphql-ws", "bearer", token}}
+		conn, _, err := dialer.Dial(JwtSourcesWsUrl, nil)
+		if !assert.NoError(t, err, "should accept websocket connection") {
+			return
+		}
+		defer conn.Close()
+
+		assert.Equal(t, "graphql-ws", conn.Subprotocol())
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, []string{"graphql-ws"}, onConnected.RequestedSubprotocols)
+		assert.Equal(t, "graphql-ws", onConnected.Subprotocol)
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+}
+
+func TestJwtAuthorizerInitFailure(t *testing.T) {
+	config := CreateTestConfig(JwtWsPort, JwtBackendUrl)
+	config.JwtConfig = &jwt.JwtConfig{
+		Enabled:      true,
+		QueryParam:   "token",
+		SecretSource: &jwt.JWKSFileProvider{FilePath: "non-existent-file.json"},
+	}
+
+	srv, err := server.CreateServerWithConfig(config)
+	assert.Error(t, err, "server should refuse to start without JWT keys")
+	assert.Nil(t, srv)
+}
+
+func mustMarshalJwks(t *testing.T, key []byte) []byte {
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{
+				Key:       key,
+				Use:       "sig",
+				Algorithm: string(jose.HS256),
+				KeyID:     JwtTestKeyId,
+			},
+		},
+	}
+	data, err := json.Marshal(jwks)
+	assert.NoError(t, err)
+	return data
+}
+
+func signTestToken(t *testing.T, key []byte, claims map[string]interface{}) string {
+	signerOptions := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", JwtTestKeyId)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: key}, signerOptions)
+	assert.NoError(t, err)
+
+	token, err := josejwt.Signed(signer).Claims(claims).Serialize()
+	assert.NoError(t, err)
+	return token
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	m "github.com/ws2wh/ws2wh/metrics/directory"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	KeepalivePongPort    = "3006"
+	KeepaliveIdlePort    = "3007"
+	KeepaliveBackendHost = ":5006"
+	KeepaliveBackendUrl  = "http://localhost:5006"
+)
+
+// TestKeepalive tests that dead and idle connections are closed with a timeout reason
+func TestKeepalive(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(KeepaliveBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	t.Run("Pong Timeout", func(t *testing.T) {
+		config := CreateTestConfig(KeepalivePongPort, KeepaliveBackendUrl)
+		config.FrontendConfig = &frontend.Config{
+			PingInterval: 50 * time.Millisecond,
+			PongWait:     200 * time.Millisecond,
+		}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		timeouts := testutil.ToFloat64(m.DisconnectCounter.WithLabelValues(m.OriginValueTimeout))
+
+		// the client never reads, so it never answers pings
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+KeepalivePongPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+		assert.Equal(t, onConnected.SessionId, onClosed.SessionId)
+		assert.Equal(t, frontend.TimeoutCloseCode, onClosed.CloseCode)
+		assert.Equal(t, frontend.PongTimeoutReason, onClosed.CloseReason)
+		assert.Equal(t, backend.CloseOriginTimeout, onClosed.CloseOrigin)
+		assert.Equal(t, timeouts+1, testutil.ToFloat64(m.DisconnectCounter.WithLabelValues(m.OriginValueTimeout)),
+			"timeouts should be counted as timeout disconnects")
+	})
+
+	t.Run("Idle Timeout", func(t *testing.T) {
+		config := CreateTestConfig(KeepaliveIdlePort, KeepaliveBackendUrl)
+		config.FrontendConfig = &frontend.Config{
+			PingInterval: 20 * time.Millisecond,
+			PongWait:     100 * time.Millisecond,
+			IdleTimeout:  300 * time.Millisecond,
+		}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+KeepaliveIdlePort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+
+		// reading answers pings, so only the idle timeout can close the connection
+		start := time.Now()
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, frontend.TimeoutCloseCode), "should receive close frame, got %v", err)
+		assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond, "keepalive should not end the connection")
+
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+		assert.Equal(t, frontend.TimeoutCloseCode, onClosed.CloseCode)
+		assert.Equal(t, frontend.IdleTimeoutReason, onClosed.CloseReason)
+		assert.Equal(t, backend.CloseOriginTimeout, onClosed.CloseOrigin)
+	})
+}
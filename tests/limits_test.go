@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	LimitsPort        = "3017"
+	LimitsBackendHost = ":5017"
+	LimitsBackendUrl  = "http://localhost:5017"
+)
+
+// TestMessageLimits tests the message size limits and UTF-8 validation in both directions
+func TestMessageLimits(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(LimitsBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	config := CreateTestConfig(LimitsPort, LimitsBackendUrl)
+	config.FrontendConfig = &frontend.Config{
+		MaxMessageSize:         8,
+		MaxOutboundMessageSize: 8,
+	}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	expectClose := func(t *testing.T, payload []byte, code int) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+LimitsPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, payload))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, code), "connection should be closed with %d: %v", code, err)
+
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event, "message should not be forwarded")
+		assert.Equal(t, code, onDisconnected.CloseCode)
+		assert.Equal(t, backend.CloseOriginServer, onDisconnected.CloseOrigin)
+	}
+
+	t.Run("InboundTooLarge", func(t *testing.T) {
+		expectClose(t, []byte(strings.Repeat("a", 9)), websocket.CloseMessageTooBig)
+	})
+
+	t.Run("InboundInvalidUtf8", func(t *testing.T) {
+		expectClose(t, []byte{0xff, 0xfe}, websocket.CloseInvalidFramePayloadData)
+	})
+
+	t.Run("Outbound", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+LimitsPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+
+		res, err := http.Post(onConnected.ReplyChannel, "text/plain", bytes.NewReader([]byte(strings.Repeat("a", 9))))
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		}
+
+		res, err = http.Post(onConnected.ReplyChannel, "text/plain", bytes.NewReader([]byte{0xff, 0xfe}))
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		}
+
+		res, err = http.Post(onConnected.ReplyChannel, "text/plain", bytes.NewReader([]byte("hello")))
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+		}
+		_, msg, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(msg), "only valid messages should be sent")
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+}
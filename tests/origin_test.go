@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	OriginPort        = "3011"
+	OriginBackendHost = ":5011"
+	OriginBackendUrl  = "http://localhost:5011"
+)
+
+// TestOriginCheck tests that upgrades from disallowed origins are rejected before a session starts
+func TestOriginCheck(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(OriginBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	config := CreateTestConfig(OriginPort, OriginBackendUrl)
+	config.FrontendConfig = &frontend.Config{
+		AllowedOrigins: []string{"https://*.example.com"},
+	}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, res, err := websocket.DefaultDialer.Dial("ws://localhost:"+OriginPort, http.Header{
+			"Origin": {"https://evil.com"},
+		})
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+		if assert.NotNil(t, res) {
+			assert.Equal(t, http.StatusForbidden, res.StatusCode)
+		}
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+OriginPort, http.Header{
+			"Origin": {"https://app.example.com"},
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event, "rejected upgrades should not reach the backend")
+	})
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	ReplyAuthBearerPort  = "3002"
+	ReplyAuthHmacPort    = "3003"
+	ReplyAuthBackendHost = ":5002"
+	ReplyAuthBackendUrl  = "http://localhost:5002"
+)
+
+// TestReplyChannelAuth tests that reply channel calls are authenticated
+// with either a shared bearer token or an HMAC signature
+func TestReplyChannelAuth(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(ReplyAuthBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	t.Run("Bearer Token", func(t *testing.T) {
+		config := CreateTestConfig(ReplyAuthBearerPort, ReplyAuthBackendUrl)
+		config.ReplyChannelConfig.AuthConfig = &server.ReplyAuthConfig{
+			Mode:        server.ReplyAuthBearer,
+			BearerToken: "secret-token",
+		}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		conn, replyUrl := connectForReplyAuth(t, wh, ReplyAuthBearerPort)
+		defer disconnectForReplyAuth(t, wh, conn)
+
+		status, message := callReplyChannel(t, replyUrl, nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "UNAUTHORIZED", message)
+
+		status, message = callReplyChannel(t, replyUrl, http.Header{"Authorization": {"Bearer wrong-token"}})
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "INVALID_TOKEN", message)
+
+		status, _ = callReplyChannel(t, replyUrl, http.Header{"Authorization": {"Bearer secret-token"}})
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("HMAC Signature", func(t *testing.T) {
+		secret := []byte("hmac-secret")
+		config := CreateTestConfig(ReplyAuthHmacPort, ReplyAuthBackendUrl)
+		config.ReplyChannelConfig.AuthConfig = &server.ReplyAuthConfig{
+			Mode:          server.ReplyAuthHmac,
+			HmacSecret:    string(secret),
+			HmacTolerance: time.Minute,
+		}
+		config.FrontendConfig = &frontend.Config{MaxOutboundMessageSize: 16}
+		wsSrv := CreateTestWsWithConfig(config)
+		wsSrv.Start()
+		defer wsSrv.Stop()
+		time.Sleep(time.Millisecond * 10)
+
+		conn, replyUrl := connectForReplyAuth(t, wh, ReplyAuthHmacPort)
+		defer disconnectForReplyAuth(t, wh, conn)
+		u, err := url.Parse(replyUrl)
+		assert.NoError(t, err)
+		sign := func(secret []byte, timestamp string, header http.Header) string {
+			parts := backend.ReplySignatureParts(http.MethodPost, u.Path, header, []byte("hello"))
+			return backend.ComputeSignature(secret, timestamp, parts...)
+		}
+
+		status, message := callReplyChannel(t, replyUrl, nil)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "UNAUTHORIZED", message)
+
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		status, message = callReplyChannel(t, replyUrl, http.Header{
+			backend.TimestampHeader: {now},
+			backend.SignatureHeader: {sign([]byte("wrong-secret"), now, nil)},
+		})
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "INVALID_SIGNATURE", message)
+
+		old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		status, message = callReplyChannel(t, replyUrl, http.Header{
+			backend.TimestampHeader: {old},
+			backend.SignatureHeader: {sign(secret, old, nil)},
+		})
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "SIGNATURE_EXPIRED", message)
+
+		// control headers are signed, so they cannot be changed in a captured request
+		status, message = callReplyChannel(t, replyUrl, http.Header{
+			backend.TimestampHeader:   {now},
+			backend.SignatureHeader:   {sign(secret, now, nil)},
+			backend.MessageTypeHeader: {"binary"},
+		})
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, "INVALID_SIGNATURE", message)
+
+		binary := http.Header{backend.MessageTypeHeader: {"binary"}}
+		status, _ = callReplyChannel(t, replyUrl, http.Header{
+			backend.TimestampHeader:   {now},
+			backend.SignatureHeader:   {sign(secret, now, binary)},
+			backend.MessageTypeHeader: {"binary"},
+		})
+		assert.Equal(t, http.StatusOK, status)
+
+		status, _ = callReplyChannel(t, replyUrl, http.Header{
+			backend.TimestampHeader: {now},
+			backend.SignatureHeader: {sign(secret, now, nil)},
+		})
+		assert.Equal(t, http.StatusOK, status)
+
+		// chunked bodies are only limited while the signature is verified
+		req, err := http.NewRequest(http.MethodPost, replyUrl, io.NopCloser(strings.NewReader(strings.Repeat("x", 32))))
+		assert.NoError(t, err)
+		req.ContentLength = -1
+		res, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+		}
+	})
+}
+
+func connectForReplyAuth(t *testing.T, wh *TestWebhook, port string) (*websocket.Conn, string) {
+	conn, _, err := websocket.DefaultDialer.Dial("ws://localhost:"+port+"?test="+uuid.NewString(), nil)
+	assert.NoError(t, err, "should accept websocket connection")
+	onConnected := wh.WaitForMessage(t, TestTimeout)
+	assert.Equal(t, backend.ClientConnected, onConnected.Event)
+	return conn, onConnected.ReplyChannel
+}
+
+func disconnectForReplyAuth(t *testing.T, wh *TestWebhook, conn *websocket.Conn) {
+	conn.Close()
+	onClosed := wh.WaitForMessage(t, TestTimeout)
+	assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+}
+
+func callReplyChannel(t *testing.T, replyUrl string, header http.Header) (int, interface{}) {
+	req, err := http.NewRequest(http.MethodPost, replyUrl, bytes.NewReader([]byte("hello")))
+	assert.NoError(t, err)
+	if header != nil {
+		req.Header = header
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+
+	var body server.SessionResponse
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return res.StatusCode, body.Message
+}
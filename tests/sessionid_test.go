@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+	"github.com/ws2wh/ws2wh/session"
+)
+
+const (
+	SessionIdPort        = "3015"
+	SessionIdReplacePort = "3016"
+	SessionIdBackendHost = ":5015"
+	SessionIdBackendUrl  = "http://localhost:5015"
+)
+
+// TestBackendSessionId tests session IDs assigned by the backend and the duplicate policies
+func TestBackendSessionId(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(SessionIdBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	config := CreateTestConfig(SessionIdPort, SessionIdBackendUrl)
+	config.FrontendConfig = &frontend.Config{BackendAdmission: true}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	replaceConfig := CreateTestConfig(SessionIdReplacePort, SessionIdBackendUrl)
+	replaceConfig.FrontendConfig = &frontend.Config{BackendAdmission: true}
+	replaceConfig.SessionIdConfig = &server.SessionIdConfig{DuplicatePolicy: server.DuplicateReplace}
+	replaceWsSrv := CreateTestWsWithConfig(replaceConfig)
+	replaceWsSrv.Start()
+	defer replaceWsSrv.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	connect := func(port string) (*websocket.Conn, *http.Response, error) {
+		wh.QueueHeader(http.Header{backend.SessionIdHeader: {"user-42:device-7"}})
+		return websocket.DefaultDialer.Dial("ws://localhost:"+port, nil)
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		conn, _, err := connect(SessionIdPort)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		onMessage := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, "user-42:device-7", onMessage.SessionId, "events should use the assigned session ID")
+		assert.Equal(t, "http://localhost:"+SessionIdPort+"/reply/user-42:device-7", onMessage.ReplyChannel)
+
+		_, res, err := connect(SessionIdPort)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+		if assert.NotNil(t, res) {
+			assert.Equal(t, http.StatusConflict, res.StatusCode)
+		}
+		wh.WaitForMessage(t, TestTimeout)
+		onRejected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onRejected.Event, "admitted duplicates should be disconnected")
+		assert.Equal(t, "user-42:device-7", onRejected.SessionId)
+		assert.Equal(t, backend.CloseOriginDuplicate, onRejected.CloseOrigin)
+
+		conn.Close()
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		first, _, err := connect(SessionIdReplacePort)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer first.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		second, _, err := connect(SessionIdReplacePort)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer second.Close()
+		wh.WaitForMessage(t, TestTimeout)
+
+		_, _, err = first.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, session.ReplacedCloseCode), "replaced session should be closed")
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+		assert.Equal(t, "user-42:device-7", onDisconnected.SessionId)
+		assert.Equal(t, backend.CloseOriginServer, onDisconnected.CloseOrigin)
+
+		assert.NoError(t, second.WriteMessage(websocket.TextMessage, []byte("hello")))
+		onMessage := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.MessageReceived, onMessage.Event, "new session should stay connected")
+
+		second.Close()
+		onDisconnected = wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+	})
+}
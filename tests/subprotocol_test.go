@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/frontend"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	SubprotocolPort        = "3012"
+	SubprotocolBackendPort = "3013"
+	SubprotocolBackendHost = ":5012"
+	SubprotocolBackendUrl  = "http://localhost:5012"
+)
+
+// TestSubprotocols tests subprotocol negotiation with a configured list and by the backend
+func TestSubprotocols(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wh := CreateTestWebhookAt(SubprotocolBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	config := CreateTestConfig(SubprotocolPort, SubprotocolBackendUrl)
+	config.FrontendConfig = &frontend.Config{
+		Subprotocols: []string{"graphql-transport-ws", "graphql-ws"},
+	}
+	wsSrv := CreateTestWsWithConfig(config)
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	backendConfig := CreateTestConfig(SubprotocolBackendPort, SubprotocolBackendUrl)
+	backendConfig.FrontendConfig = &frontend.Config{BackendSubprotocol: true}
+	backendWsSrv := CreateTestWsWithConfig(backendConfig)
+	backendWsSrv.Start()
+	defer backendWsSrv.Stop()
+	time.Sleep(time.Millisecond * 10)
+
+	t.Run("Configured", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"mqtt", "graphql-ws", "graphql-transport-ws"}}
+		conn, _, err := dialer.Dial("ws://localhost:"+SubprotocolPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		assert.Equal(t, "graphql-transport-ws", conn.Subprotocol(), "server preference should be negotiated")
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		assert.Equal(t, "graphql-transport-ws", onConnected.Subprotocol)
+
+		assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		onMessage := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.MessageReceived, onMessage.Event)
+		assert.Equal(t, "graphql-transport-ws", onMessage.Subprotocol)
+
+		conn.Close()
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+		assert.Equal(t, "graphql-transport-ws", onDisconnected.Subprotocol)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		dialer := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+		conn, _, err := dialer.Dial("ws://localhost:"+SubprotocolPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		assert.Empty(t, conn.Subprotocol())
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Empty(t, onConnected.Subprotocol)
+
+		conn.Close()
+		wh.WaitForMessage(t, TestTimeout)
+	})
+
+	t.Run("Backend", func(t *testing.T) {
+		wh.QueueHeader(http.Header{
+			backend.SubprotocolHeader: {"mqtt"},
+		})
+
+		dialer := websocket.Dialer{Subprotocols: []string{"graphql-ws", "mqtt"}}
+		conn, _, err := dialer.Dial("ws://localhost:"+SubprotocolBackendPort, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+
+		onConnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientConnected, onConnected.Event)
+		assert.Equal(t, []string{"graphql-ws", "mqtt"}, onConnected.RequestedSubprotocols)
+		assert.Equal(t, "mqtt", conn.Subprotocol(), "backend selection should be negotiated")
+
+		conn.Close()
+		onDisconnected := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onDisconnected.Event)
+		assert.Equal(t, "mqtt", onDisconnected.Subprotocol)
+	})
+}
@@ -1,10 +1,14 @@
 package tests
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,10 +20,19 @@ type TestWebhook struct {
 	httpHandler http.Handler
 	messages    chan backend.BackendMessage
 	responses   [][]byte
+	headers     []http.Header
+	statuses    []int
 	server      *http.Server
+	// lock guards the queued responses, headers and statuses shared between tests and the handler
+	lock sync.Mutex
 }
 
 func CreateTestWebhook() *TestWebhook {
+	return CreateTestWebhookAt(BackendHost)
+}
+
+// CreateTestWebhookAt creates a test webhook listening on the given address
+func CreateTestWebhookAt(addr string) *TestWebhook {
 	httpHandler := mux.NewRouter()
 	b := TestWebhook{
 		messages:    make(chan backend.BackendMessage, 128),
@@ -30,7 +43,7 @@ func CreateTestWebhook() *TestWebhook {
 
 	httpHandler.Methods("POST").Path("/").HandlerFunc(b.handler)
 	b.server = &http.Server{
-		Addr:    BackendHost,
+		Addr:    addr,
 		Handler: b.httpHandler,
 	}
 
@@ -51,18 +64,94 @@ func (b *TestWebhook) handler(w http.ResponseWriter, r *http.Request) {
 		Payload:      p,
 	}
 
+	if mt := r.Header.Get(backend.MessageTypeHeader); mt != "" {
+		msg.MessageType, _ = backend.ParseMessageType(mt)
+	}
+
+	msg.ClientIp = r.Header.Get(backend.ClientIpHeader)
+	msg.Subprotocol = r.Header.Get(backend.SubprotocolHeader)
+	if requested := r.Header.Get(backend.RequestedSubprotocolsHeader); requested != "" {
+		msg.RequestedSubprotocols = strings.Split(requested, ", ")
+	}
+	msg.ConnectedAt, _ = time.Parse(time.RFC3339, r.Header.Get(backend.ConnectedAtHeader))
+	for name, values := range r.Header {
+		if forwarded, ok := strings.CutPrefix(name, backend.ForwardedHeaderPrefix); ok {
+			if msg.Headers == nil {
+				msg.Headers = http.Header{}
+			}
+			msg.Headers[forwarded] = values
+		}
+	}
+
+	if claims := r.Header.Get(backend.JwtClaimsHeader); claims != "" {
+		msg.JwtClaims = &claims
+	}
+
+	if code := r.Header.Get(backend.CloseCodeHeader); code != "" {
+		msg.CloseCode, _ = strconv.Atoi(code)
+		msg.CloseReason = r.Header.Get(backend.CloseReasonHeader)
+	}
+	msg.CloseOrigin = backend.CloseOrigin(r.Header.Get(backend.CloseOriginHeader))
+
 	b.messages <- msg
-	if len(b.responses) == 0 {
-		w.WriteHeader(http.StatusNoContent)
+	header, status, resp, ok := b.next()
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+
+	if !ok {
+		w.WriteHeader(cmp.Or(status, http.StatusNoContent))
 	} else {
-		resp := b.responses[0]
-		b.responses = b.responses[1:]
 		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
+		w.WriteHeader(cmp.Or(status, http.StatusOK))
 		w.Write(resp)
 	}
 }
 
+// next pops the next queued header, status and response (ok is false if no response is queued)
+func (b *TestWebhook) next() (header http.Header, status int, resp []byte, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.headers) > 0 {
+		header = b.headers[0]
+		b.headers = b.headers[1:]
+	}
+
+	if len(b.statuses) > 0 {
+		status = b.statuses[0]
+		b.statuses = b.statuses[1:]
+	}
+
+	if len(b.responses) > 0 {
+		resp, ok = b.responses[0], true
+		b.responses = b.responses[1:]
+	}
+
+	return header, status, resp, ok
+}
+
+// QueueResponse queues a response body for a later webhook request
+func (b *TestWebhook) QueueResponse(resp []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.responses = append(b.responses, resp)
+}
+
+// QueueHeader queues response headers for a later webhook request
+func (b *TestWebhook) QueueHeader(header http.Header) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.headers = append(b.headers, header)
+}
+
+// QueueStatus queues a response status code for a later webhook request
+func (b *TestWebhook) QueueStatus(status int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.statuses = append(b.statuses, status)
+}
+
 func (b *TestWebhook) Start() {
 	go func() {
 		if err := b.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -71,8 +160,10 @@ func (b *TestWebhook) Start() {
 	}()
 }
 
+// Stop closes the webhook server without waiting for idle client connections
+// Connections dialed by the webhook client but never used would delay a graceful shutdown by 5 seconds
 func (b *TestWebhook) Stop() {
-	b.server.Shutdown(context.Background())
+	b.server.Close()
 }
 
 func (b *TestWebhook) WaitForMessage(t *testing.T, timeout time.Duration) backend.BackendMessage {
@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 
 	"github.com/ws2wh/ws2wh/metrics"
@@ -14,29 +15,45 @@ const BackendHost = ":5000"
 const BackendUrl = "http://localhost:5000"
 
 func CreateTestWs() TestWsServer {
-	ctx, cancel := context.WithCancel(context.Background())
-	return TestWsServer{
+	return CreateTestWsWithConfig(CreateTestConfig("3000", BackendUrl))
+}
 
-		server: server.CreateServerWithConfig(&server.Config{
-			BackendUrl:        BackendUrl,
-			WebSocketListener: WsHost,
-			WebSocketPath:     "/",
-			ReplyChannelConfig: &server.ReplyChannelConfig{
-				PathPrefix: "/reply",
-				Hostname:   "localhost",
-				Scheme:     "http",
-				Port:       "3000",
-			},
-			LogLevel: slog.LevelDebug,
-			Hostname: "localhost",
-			MetricsConfig: &metrics.MetricsConfig{
-				Enabled: false,
-			},
-			TlsConfig: &server.TlsConfig{
-				Enabled: false,
-			},
-		}),
+// CreateTestConfig returns a server configuration listening on the given port
+// and forwarding to the given backend URL
+func CreateTestConfig(port string, backendUrl string) *server.Config {
+	return &server.Config{
+		BackendUrl:        backendUrl,
+		WebSocketListener: ":" + port,
+		WebSocketPath:     "/",
+		ReplyChannelConfig: &server.ReplyChannelConfig{
+			PathPrefix:       "/reply",
+			Hostname:         "localhost",
+			Scheme:           "http",
+			Port:             port,
+			BroadcastPath:    "/broadcast",
+			MulticastPath:    "/multicast",
+			TopicsPathPrefix: "/topics",
+		},
+		LogLevel: slog.LevelDebug,
+		Hostname: "localhost",
+		MetricsConfig: &metrics.MetricsConfig{
+			Enabled: false,
+		},
+		TlsConfig: &server.TlsConfig{
+			Enabled: false,
+		},
+	}
+}
 
+func CreateTestWsWithConfig(config *server.Config) TestWsServer {
+	srv, err := server.CreateServerWithConfig(config)
+	if err != nil {
+		panic(fmt.Sprintf("Test websocket server error: %v", err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return TestWsServer{
+		server:  srv,
 		context: ctx,
 		stop:    cancel,
 	}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/ws2wh/ws2wh/backend"
+	"github.com/ws2wh/ws2wh/cmd/logger"
+	"github.com/ws2wh/ws2wh/server"
+)
+
+const (
+	TopicsWsPort      = "3005"
+	TopicsWsUrl       = "ws://localhost:3005"
+	TopicsHttpUrl     = "http://localhost:3005"
+	TopicsBackendHost = ":5005"
+	TopicsBackendUrl  = "http://localhost:5005"
+)
+
+// TestTopicSubscriptions tests subscribing sessions to topics and publishing messages to them
+func TestTopicSubscriptions(t *testing.T) {
+	logger.InitLogger(&server.Config{
+		LogLevel: slog.LevelDebug,
+	})
+
+	wsSrv := CreateTestWsWithConfig(CreateTestConfig(TopicsWsPort, TopicsBackendUrl))
+	wsSrv.Start()
+	defer wsSrv.Stop()
+
+	wh := CreateTestWebhookAt(TopicsBackendHost)
+	wh.Start()
+	defer wh.Stop()
+
+	time.Sleep(time.Millisecond * 10)
+
+	// subscribed by the backend in the client-connected response
+	wh.QueueHeader(http.Header{
+		backend.CommandHeader: {backend.SubscribeCommand},
+		backend.TopicsHeader:  {"news"},
+	})
+	connA, _, err := websocket.DefaultDialer.Dial(TopicsWsUrl, nil)
+	assert.NoError(t, err)
+	defer connA.Close()
+	onConnectedA := wh.WaitForMessage(t, TestTimeout)
+
+	// subscribed by the backend through the reply channel
+	connB, _, err := websocket.DefaultDialer.Dial(TopicsWsUrl, nil)
+	assert.NoError(t, err)
+	defer connB.Close()
+	onConnectedB := wh.WaitForMessage(t, TestTimeout)
+	callTopicCommand(t, onConnectedB.ReplyChannel, backend.SubscribeCommand, "news,sports")
+
+	t.Run("Publish To All Subscribers", func(t *testing.T) {
+		chanA := make(chan []byte, 1)
+		chanB := make(chan []byte, 1)
+		go captureMessage(connA, chanA)
+		go captureMessage(connB, chanB)
+
+		expectedMsg := []byte(uuid.NewString())
+		results := callFanout(t, TopicsHttpUrl+"/topics/news", expectedMsg)
+
+		assert.Len(t, results, 2, "should deliver to every subscriber")
+		assert.Equal(t, expectedMsg, waitForMessage(t, chanA))
+		assert.Equal(t, expectedMsg, waitForMessage(t, chanB))
+	})
+
+	t.Run("Publish After Unsubscribe", func(t *testing.T) {
+		callTopicCommand(t, onConnectedA.ReplyChannel, backend.UnsubscribeCommand, "news")
+
+		chanB := make(chan []byte, 1)
+		go captureMessage(connB, chanB)
+
+		expectedMsg := []byte(uuid.NewString())
+		results := callFanout(t, TopicsHttpUrl+"/topics/news", expectedMsg)
+
+		if assert.Len(t, results, 1, "should deliver to remaining subscriber only") {
+			assert.Equal(t, onConnectedB.SessionId, results[0].SessionId)
+		}
+		assert.Equal(t, expectedMsg, waitForMessage(t, chanB))
+	})
+
+	t.Run("Subscriptions Removed On Disconnect", func(t *testing.T) {
+		connB.Close()
+		onClosed := wh.WaitForMessage(t, TestTimeout)
+		assert.Equal(t, backend.ClientDisconnected, onClosed.Event)
+
+		results := callFanout(t, TopicsHttpUrl+"/topics/sports", []byte(uuid.NewString()))
+		assert.Empty(t, results, "should not deliver to disconnected session")
+	})
+
+	t.Run("Subscribe Without Topics", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodPost, onConnectedA.ReplyChannel, nil)
+		req.Header.Set(backend.CommandHeader, backend.SubscribeCommand)
+		res, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			defer res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		}
+	})
+}
+
+func callTopicCommand(t *testing.T, replyUrl string, command string, topics string) {
+	req, err := http.NewRequest(http.MethodPost, replyUrl, bytes.NewReader(nil))
+	assert.NoError(t, err)
+	req.Header.Set(backend.CommandHeader, command)
+	req.Header.Set(backend.TopicsHeader, topics)
+
+	res, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}